@@ -0,0 +1,125 @@
+package apikey
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"authorizer/quota"
+)
+
+const (
+	// HMAC-SHA256 of the salted key with pepper. Keys are random, so a slow hash would add nothing.
+	schemeHmac   = "hmac-sha256:"
+	keyPrefix    = "isk_"
+	secretPrefix = "iss_"
+	keyBytes     = 32
+	saltBytes    = 16
+)
+
+// Hash of a key which is still accepted after rotation
+type Previous struct {
+	Hash    string    `json:"hash"`
+	Salt    string    `json:"salt,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Value of the SSM parameter of one key
+type Entry struct {
+	Hash string `json:"hash"`
+	// Empty for entries made before salting
+	Salt     string     `json:"salt,omitempty"`
+	Previous []Previous `json:"previous,omitempty"`
+	Scopes
+	// Overrides the default limits of the authorizer
+	Limits *quota.Limits `json:"limits,omitempty"`
+	// Shared secret of signed requests. It can't be hashed, the authorizer has to sign with it too.
+	SigningSecret string `json:"signingSecret,omitempty"`
+}
+
+// Parses parameter value. A value which isn't JSON is a plain key from before hashing, it is hashed in memory.
+func ParseEntry(pepper []byte, value string) (Entry, bool, error) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return Entry{Hash: Hash(pepper, "", value)}, true, nil
+	}
+	var entry Entry
+	err := json.Unmarshal([]byte(value), &entry)
+	if err != nil {
+		return entry, false, errors.Join(errors.New("Invalid key entry"), err)
+	}
+	if entry.Hash == "" && entry.SigningSecret == "" {
+		return entry, false, errors.New("Key entry needs hash or signingSecret")
+	}
+	if entry.Hash != "" && !strings.HasPrefix(entry.Hash, schemeHmac) {
+		return entry, false, fmt.Errorf("Unsupported hash scheme of %q", entry.Hash)
+	}
+	return entry, false, nil
+}
+
+// Keeps the current hash valid until expires and sets the new one
+func (e Entry) Rotate(hash string, salt string, expires time.Time) Entry {
+	rotated := Entry{Hash: hash, Salt: salt, Scopes: e.Scopes, Limits: e.Limits, SigningSecret: e.SigningSecret}
+	if e.Hash != "" {
+		rotated.Previous = append(rotated.Previous, Previous{Hash: e.Hash, Salt: e.Salt, Expires: expires})
+	}
+	for _, prev := range e.Previous {
+		if expires.Before(prev.Expires) {
+			prev.Expires = expires
+		}
+		rotated.Previous = append(rotated.Previous, prev)
+	}
+	return rotated
+}
+
+// The pepper, stored apart from the hashes, makes leaked hashes useless without it.
+// The salt makes hashes of the same key differ between entries and stacks, so leaked hashes
+// can't be matched against each other or against a list of guesses at once.
+// Empty salt gives the hash of entries made before salting.
+func Hash(pepper []byte, salt string, key string) string {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(salt))
+	mac.Write([]byte(key))
+	return schemeHmac + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Constant-time check of the key against a stored hash
+func Verify(pepper []byte, salt string, key string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(pepper, salt, key)), []byte(hash)) == 1
+}
+
+// New random salt of an entry, fixed length hex so it can't run into the key
+func NewSalt() (string, error) {
+	b := make([]byte, saltBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// New random key to hand over to the client
+func Generate() (string, error) {
+	return randomToken(keyPrefix)
+}
+
+// New random secret for signed requests
+func GenerateSecret() (string, error) {
+	return randomToken(secretPrefix)
+}
+
+func randomToken(prefix string) (string, error) {
+	b := make([]byte, keyBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,94 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashVerify(t *testing.T) {
+	pepper := []byte("pepper")
+	key, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, keyPrefix) {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := Hash(pepper, salt, key)
+	if !Verify(pepper, salt, key, hash) {
+		t.Error("key should match its hash")
+	}
+	if Verify([]byte("other"), salt, key, hash) {
+		t.Error("hash depends on pepper")
+	}
+	if Verify(pepper, salt, key+"x", hash) {
+		t.Error("different key should not match")
+	}
+
+	other, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == salt || len(salt) != 2*saltBytes {
+		t.Errorf("unexpected salts %s %s", salt, other)
+	}
+	if Verify(pepper, other, key, hash) || Verify(pepper, "", key, hash) {
+		t.Error("hash depends on salt")
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	pepper := []byte("pepper")
+
+	entry, legacy, err := ParseEntry(pepper, "plain-secret")
+	if err != nil || !legacy || !Verify(pepper, "", "plain-secret", entry.Hash) {
+		t.Errorf("plain value should be hashed, got %+v %v %v", entry, legacy, err)
+	}
+
+	entry, legacy, err = ParseEntry(pepper, `{"hash": "`+Hash(pepper, "", "k")+`"}`)
+	if err != nil || legacy || !Verify(pepper, "", "k", entry.Hash) {
+		t.Errorf("unexpected %+v %v %v", entry, legacy, err)
+	}
+
+	entry, _, err = ParseEntry(pepper, `{"hash": "`+Hash(pepper, "abc", "k")+`", "salt": "abc"}`)
+	if err != nil || !Verify(pepper, entry.Salt, "k", entry.Hash) {
+		t.Errorf("unexpected salted %+v %v", entry, err)
+	}
+
+	_, _, err = ParseEntry(pepper, `{"hash": "md5:abc"}`)
+	if err == nil {
+		t.Error("unknown scheme should be rejected")
+	}
+
+	entry, _, err = ParseEntry(pepper, `{"signingSecret": "iss_abc"}`)
+	if err != nil || entry.Hash != "" || len(entry.Previous) != 0 {
+		t.Errorf("signing only entry should have no hashes, got %+v %v", entry, err)
+	}
+
+	_, _, err = ParseEntry(pepper, `{"routes": ["/dmq/make"]}`)
+	if err == nil {
+		t.Error("entry without hash and signingSecret should be rejected")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	now := time.Date(2025, 5, 28, 10, 0, 0, 0, time.UTC)
+	entry := Entry{Hash: "hmac-sha256:old"}
+
+	rotated := entry.Rotate("hmac-sha256:new", "s1", now.Add(24*time.Hour))
+	if rotated.Hash != "hmac-sha256:new" || rotated.Salt != "s1" || len(rotated.Previous) != 1 || rotated.Previous[0] != (Previous{Hash: "hmac-sha256:old", Expires: now.Add(24 * time.Hour)}) {
+		t.Errorf("unexpected rotation %+v", rotated)
+	}
+
+	// Shorter grace of the next rotation cuts the older ones too, the salt goes with its hash
+	rotated = rotated.Rotate("hmac-sha256:newer", "s2", now)
+	if len(rotated.Previous) != 2 || rotated.Previous[0].Hash != "hmac-sha256:new" || rotated.Previous[0].Salt != "s1" || !rotated.Previous[1].Expires.Equal(now) {
+		t.Errorf("unexpected second rotation %+v", rotated)
+	}
+}
@@ -0,0 +1,84 @@
+package apikey
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// What the key may do. Empty lists mean no restriction, as keys had before scopes.
+type Scopes struct {
+	// Route patterns without the version prefix, e.g. /dmq/make or /video-render/*
+	Routes []string `json:"routes,omitempty"`
+	// State machine names or ARNs, may contain * wildcards
+	StateMachines []string   `json:"stateMachines,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+}
+
+func (s Scopes) Expired(now time.Time) bool {
+	return s.Expires != nil && !now.Before(*s.Expires)
+}
+
+func (s Scopes) AllowsRoute(requestPath string) bool {
+	if len(s.Routes) == 0 {
+		return true
+	}
+	unversioned := stripVersion(requestPath)
+	for _, pattern := range s.Routes {
+		if MatchRoute(pattern, unversioned) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pattern segments must match path segments, * matches one segment.
+// Trailing /* matches the rest of the path at any depth.
+func MatchRoute(pattern, requestPath string) bool {
+	patternSegs := segments(pattern)
+	pathSegs := segments(requestPath)
+	for i, seg := range patternSegs {
+		if seg == "*" && i == len(patternSegs)-1 {
+			return len(pathSegs) > i
+		}
+		if i >= len(pathSegs) {
+			return false
+		}
+		ok, err := path.Match(seg, pathSegs[i])
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return len(patternSegs) == len(pathSegs)
+}
+
+// Drops leading segments up to the version, /unstable/v2/dmq/make -> /dmq/make
+func stripVersion(requestPath string) string {
+	segs := segments(requestPath)
+	for i, seg := range segs {
+		if isVersion(seg) {
+			return "/" + strings.Join(segs[i+1:], "/")
+		}
+	}
+	return requestPath
+}
+
+func isVersion(seg string) bool {
+	if len(seg) < 2 || seg[0] != 'v' {
+		return false
+	}
+	for _, c := range seg[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func segments(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,55 @@
+package apikey
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowsRoute(t *testing.T) {
+	scopes := Scopes{Routes: []string{"/dmq/make", "/video-render/*"}}
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"/unstable/v2/dmq/make", true},
+		{"/v1/dmq/make", true},
+		{"/unstable/v2/dmq/make/extra", false},
+		{"/unstable/v2/dmq/status/{jobId}", false},
+		{"/unstable/v2/video-render/reel", true},
+		{"/unstable/v2/video-render/status/abc", true},
+		{"/unstable/v2/video-render", false},
+	}
+	for _, c := range cases {
+		if got := scopes.AllowsRoute(c.path); got != c.want {
+			t.Errorf("AllowsRoute(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	if !(Scopes{}).AllowsRoute("/unstable/v2/anything") {
+		t.Error("empty routes should allow everything")
+	}
+}
+
+func TestMatchRouteSegmentWildcard(t *testing.T) {
+	if !MatchRoute("/dmq/*/status", "/dmq/x/status") {
+		t.Error("expected single segment wildcard to match")
+	}
+	if MatchRoute("/dmq/*/status", "/dmq/x/y/status") {
+		t.Error("single segment wildcard matched two segments")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	if (Scopes{}).Expired(now) {
+		t.Error("key without expiry expired")
+	}
+	if !(Scopes{Expires: &past}).Expired(now) {
+		t.Error("expected key to be expired")
+	}
+	if (Scopes{Expires: &future}).Expired(now) {
+		t.Error("key expired too early")
+	}
+}
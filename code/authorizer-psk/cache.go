@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Value loaded on demand and kept for ttl. When reload fails the old value is served
+// for up to maxStale after it expired, so a short SSM outage doesn't lock out all clients.
+type cache[T any] struct {
+	ttl      time.Duration
+	maxStale time.Duration
+	load     func(ctx context.Context) (T, error)
+	now      func() time.Time
+
+	// Serializes reloads, concurrent callers wait for a single load
+	mu       sync.Mutex
+	value    T
+	loadedAt time.Time
+	loaded   bool
+}
+
+func newCache[T any](ttl time.Duration, maxStale time.Duration, load func(ctx context.Context) (T, error)) *cache[T] {
+	return &cache[T]{ttl: ttl, maxStale: maxStale, load: load, now: time.Now}
+}
+
+func (c *cache[T]) Get(ctx context.Context) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	age := c.now().Sub(c.loadedAt)
+	if c.loaded && age < c.ttl {
+		return c.value, nil
+	}
+
+	value, err := c.load(ctx)
+	if err == nil {
+		c.value = value
+		c.loadedAt = c.now()
+		c.loaded = true
+		return value, nil
+	}
+
+	if c.loaded && age < c.ttl+c.maxStale {
+		log.Warn("Reload failed, serving value loaded ", age.Round(time.Second), " ago. ", err)
+		return c.value, nil
+	}
+	var zero T
+	if c.loaded {
+		return zero, errors.Join(errors.New("Reload failed and cached value is too old"), err)
+	}
+	return zero, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2025, 5, 28, 10, 0, 0, 0, time.UTC)
+	loads := 0
+	var failure error
+	c := newCache(time.Minute, time.Hour, func(ctx context.Context) (int, error) {
+		if failure != nil {
+			return 0, failure
+		}
+		loads++
+		return loads, nil
+	})
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	get := func(want int, wantErr bool) {
+		t.Helper()
+		value, err := c.Get(ctx)
+		if (err != nil) != wantErr || (!wantErr && value != want) {
+			t.Errorf("got %d, %v; want %d, error %v", value, err, want, wantErr)
+		}
+	}
+
+	get(1, false)
+	now = now.Add(30 * time.Second)
+	get(1, false)
+
+	// Expired, reloaded
+	now = now.Add(time.Minute)
+	get(2, false)
+
+	// Expired and reload fails, stale value is served
+	failure = errors.New("ssm unreachable")
+	now = now.Add(30 * time.Minute)
+	get(2, false)
+
+	// Too old
+	now = now.Add(time.Hour)
+	get(0, true)
+
+	// Recovered
+	failure = nil
+	get(3, false)
+}
+
+func TestCacheFirstLoadFails(t *testing.T) {
+	c := newCache(time.Minute, time.Hour, func(ctx context.Context) (int, error) {
+		return 0, errors.New("ssm unreachable")
+	})
+	_, err := c.Get(context.Background())
+	if err == nil {
+		t.Error("expected error")
+	}
+}
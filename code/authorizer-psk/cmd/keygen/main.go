@@ -0,0 +1,189 @@
+// Generates a new API key and its hashed parameter store entry.
+// The plain key is printed only once, hand it over to the client.
+// With -import the key is read from stdin instead, e.g. to move a usage plan key to parameter store.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"authorizer/apikey"
+)
+
+func main() {
+	name := flag.String("name", "", "key name, e.g. GR/cz")
+	stack := flag.String("stack", "", "pulumi stack the key is for, e.g. live")
+	path := flag.String("path", "", "base path of keys in parameter store, /isha/auth/<stack> when empty")
+	pepperPath := flag.String("pepper-path", "", "SecureString parameter with the pepper, /isha/auth-pepper/<stack> when empty")
+	grace := flag.Duration("grace", 0, "keep the current key valid this long after rotation, zero revokes it at once")
+	write := flag.Bool("write", false, "write the entry to parameter store")
+	routes := flag.String("routes", "", "comma separated route patterns the key may call, e.g. /dmq/make,/video-render/*")
+	stateMachines := flag.String("state-machines", "", "comma separated state machine names the key may start")
+	expires := flag.String("expires", "", "date (2006-01-02) when the key stops working")
+	signing := flag.Bool("signing", false, "also generate a new secret for signed requests")
+	importKey := flag.Bool("import", false, "hash the existing key read from stdin instead of generating a new one")
+	flag.Parse()
+
+	scopes, err := parseScopes(*routes, *stateMachines, *expires)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	keysPath, keysPepperPath, err := stackPaths(*stack, *path, *pepperPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var existing string
+	if *importKey {
+		existing, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+		existing = strings.TrimSpace(existing)
+		if existing == "" {
+			fmt.Fprintln(os.Stderr, "-import expects the key on stdin")
+			os.Exit(2)
+		}
+	}
+
+	err = run(context.Background(), *name, keysPath, keysPepperPath, *grace, *write, *signing, existing, scopes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Same paths as the stack gives the authorizer, see helperLambda.ts
+func stackPaths(stack, path, pepperPath string) (string, string, error) {
+	if stack == "" && (path == "" || pepperPath == "") {
+		return "", "", errors.New("-stack is required, or both -path and -pepper-path")
+	}
+	if path == "" {
+		path = "/isha/auth/" + stack
+	}
+	if pepperPath == "" {
+		pepperPath = "/isha/auth-pepper/" + stack
+	}
+	return strings.TrimSuffix(path, "/"), pepperPath, nil
+}
+
+// Only the given flags override scopes of a rotated key
+func parseScopes(routes, stateMachines, expires string) (func(*apikey.Scopes), error) {
+	var expiresAt *time.Time
+	if expires != "" {
+		t, err := time.Parse(time.DateOnly, expires)
+		if err != nil {
+			return nil, fmt.Errorf("-expires expected date like 2006-01-02, got %q", expires)
+		}
+		expiresAt = &t
+	}
+	return func(s *apikey.Scopes) {
+		if routes != "" {
+			s.Routes = strings.Split(routes, ",")
+		}
+		if stateMachines != "" {
+			s.StateMachines = strings.Split(stateMachines, ",")
+		}
+		if expiresAt != nil {
+			s.Expires = expiresAt
+		}
+	}, nil
+}
+
+// Empty existing generates a new key
+func run(ctx context.Context, name, path, pepperPath string, grace time.Duration, write bool, signing bool, existing string, scopes func(*apikey.Scopes)) error {
+	if name == "" {
+		return errors.New("-name is required")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return errors.Join(errors.New("error loading AWS config"), err)
+	}
+	ssmc := ssm.NewFromConfig(cfg)
+
+	pepperParam, err := ssmc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(pepperPath),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return errors.Join(errors.New("error reading pepper"), err)
+	}
+	pepper := []byte(*pepperParam.Parameter.Value)
+
+	paramName := path + "/" + name
+	// An existing key is rotated, so its scopes, limits and signing secret are kept
+	entry := apikey.Entry{}
+	current, err := ssmc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(paramName),
+		WithDecryption: aws.Bool(true),
+	})
+	var notFound *types.ParameterNotFound
+	if err != nil && !errors.As(err, &notFound) {
+		return errors.Join(fmt.Errorf("error reading current key %s", paramName), err)
+	}
+	if err == nil {
+		entry, _, err = apikey.ParseEntry(pepper, *current.Parameter.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	key := existing
+	if key == "" {
+		key, err = apikey.Generate()
+		if err != nil {
+			return errors.Join(errors.New("error generating key"), err)
+		}
+	}
+	salt, err := apikey.NewSalt()
+	if err != nil {
+		return errors.Join(errors.New("error generating salt"), err)
+	}
+	entry = entry.Rotate(apikey.Hash(pepper, salt, key), salt, time.Now().Add(grace))
+	scopes(&entry.Scopes)
+	if signing {
+		entry.SigningSecret, err = apikey.GenerateSecret()
+		if err != nil {
+			return errors.Join(errors.New("error generating signing secret"), err)
+		}
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if existing == "" {
+		fmt.Println("key:", key)
+	}
+	if signing {
+		fmt.Println("signing secret:", entry.SigningSecret)
+	}
+	fmt.Println("entry:", string(value))
+
+	if !write {
+		return nil
+	}
+	_, err = ssmc.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(paramName),
+		Value:     aws.String(string(value)),
+		Type:      types.ParameterTypeSecureString,
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("error writing %s", paramName), err)
+	}
+	fmt.Println("written to", paramName)
+	return nil
+}
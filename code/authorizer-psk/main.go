@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -11,13 +15,38 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"authorizer/apikey"
+	"authorizer/quota"
+	"authorizer/signature"
+)
+
+const (
+	defaultKeysTTL       = 5 * time.Minute
+	defaultKeysMaxStale  = time.Hour
+	defaultRevocationTTL = 30 * time.Second
+
+	reasonInvalidKey      = "invalid-key"
+	reasonRevoked         = "revoked"
+	reasonRouteNotAllowed = "route-not-allowed"
 )
 
 var (
-	log        *zap.SugaredLogger
-	ssmc       *ssm.Client
-	cachedKeys map[string]string
+	log     *zap.SugaredLogger
+	ssmc    *ssm.Client
+	keys    *cache[keyRing]
+	revoked *cache[map[string]bool]
+	// Nil when QUOTA_TABLE isn't set, requests are not limited then
+	limiter       *quota.Limiter
+	defaultLimits quota.Limits
+	// Used nonces of signed requests, shared with the quota table when set
+	nonces        quota.Store
+	signatureSkew time.Duration
+	// Plain keys from before hashing, set ALLOW_PLAIN_KEYS=false once all of them are replaced
+	allowPlainKeys bool
 )
 
 func getSSMPath() string {
@@ -31,16 +60,75 @@ func getSSMPath() string {
 	return ssmPath
 }
 
-func getParameters(ctx context.Context) (map[string]string, error) {
-	if cachedKeys != nil {
-		return cachedKeys, nil
+type keyRef struct {
+	name    string
+	hash    string
+	salt    string
+	expires time.Time
+	scopes  apikey.Scopes
+	limits  *quota.Limits
+	// Empty when the key can't sign requests
+	signingSecret string
+}
+
+// Hashes of all accepted keys and the pepper they are made with
+type keyRing struct {
+	pepper []byte
+	// Unsalted hashes, from before salting and of plain keys
+	byHash map[string]keyRef
+	// The key can't be hashed before its salt is known, so these are tried one by one
+	salted []keyRef
+	// Keys with signing secret, signed requests name the key
+	byName map[string]keyRef
+}
+
+func (r *keyRing) add(ref keyRef) {
+	if ref.salt == "" {
+		r.byHash[ref.hash] = ref
+		return
+	}
+	r.salted = append(r.salted, ref)
+}
+
+// Unsalted hash is found at once, salted ones cost a HMAC each, which is cheap for the few keys of the API
+func (r keyRing) find(apiKey string) (keyRef, bool) {
+	if ref, ok := r.byHash[apikey.Hash(r.pepper, "", apiKey)]; ok {
+		return ref, true
+	}
+	for _, ref := range r.salted {
+		if apikey.Verify(r.pepper, ref.salt, apiKey, ref.hash) {
+			return ref, true
+		}
+	}
+	return keyRef{}, false
+}
+
+// Secret mixed into key hashes, kept apart from the hashes so leaked hashes can't be brute forced
+func loadPepper(ctx context.Context) ([]byte, error) {
+	path := os.Getenv("SSM_PEPPER_PATH")
+	if path == "" {
+		return nil, nil
+	}
+	response, err := ssmc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(path),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Join(errors.New("error reading pepper"), err)
 	}
+	return []byte(*response.Parameter.Value), nil
+}
 
+func loadKeys(ctx context.Context) (keyRing, error) {
 	ssmPath := getSSMPath()
 
-	log.Debug("Loading keys from parameter store. Path: ", ssmPath)
+	pepper, err := loadPepper(ctx)
+	if err != nil {
+		return keyRing{}, err
+	}
+	ring := keyRing{pepper: pepper, byHash: make(map[string]keyRef), byName: make(map[string]keyRef)}
 
-	keyMap := make(map[string]string)
+	log.Debug("Loading keys from parameter store. Path: ", ssmPath)
 
 	firstRun := true
 	var nextToken *string = nil
@@ -55,24 +143,138 @@ func getParameters(ctx context.Context) (map[string]string, error) {
 			NextToken:      nextToken,
 		})
 		if err != nil {
-			return nil, err
+			return keyRing{}, err
 		}
 
 		nextToken = response.NextToken
 
 		for _, param := range response.Parameters {
+			if *param.Name == os.Getenv("SSM_REVOCATION_PATH") || *param.Name == os.Getenv("SSM_PEPPER_PATH") {
+				continue
+			}
 			name := strings.TrimPrefix(*param.Name, ssmPath+"/")
-			keyMap[*param.Value] = name
+			entry, plain, err := apikey.ParseEntry(pepper, *param.Value)
+			if err != nil {
+				log.Error("skipping key ", name, ": ", err)
+				continue
+			}
+			if plain && !allowPlainKeys {
+				log.Error("skipping key ", name, ": stored in plain text and ALLOW_PLAIN_KEYS is false")
+				continue
+			}
+			if plain {
+				log.Error("key ", name, " is stored in plain text, replace it with a hash")
+			} else if pepper == nil && entry.Hash != "" {
+				// Without the pepper no hash can match, every request with the key would be denied
+				return keyRing{}, fmt.Errorf("key %s is hashed, SSM_PEPPER_PATH is required", name)
+			}
+
+			if entry.Hash != "" {
+				ring.add(keyRef{name: name, hash: entry.Hash, salt: entry.Salt, scopes: entry.Scopes, limits: entry.Limits})
+			}
+			if entry.SigningSecret != "" {
+				ring.byName[name] = keyRef{name: name, scopes: entry.Scopes, limits: entry.Limits, signingSecret: entry.SigningSecret}
+			}
+			for _, prev := range entry.Previous {
+				ring.add(keyRef{name: name, hash: prev.Hash, salt: prev.Salt, expires: prev.Expires, scopes: entry.Scopes, limits: entry.Limits})
+			}
+		}
+	}
+
+	log.Debug("Loaded ", len(ring.byHash)+len(ring.salted), " API key hashes")
+
+	return ring, nil
+}
+
+// Names of keys which are denied even though they are still in the parameter store.
+// The parameter is a StringList, missing parameter means nothing is revoked.
+func loadRevoked(ctx context.Context) (map[string]bool, error) {
+	revokedMap := make(map[string]bool)
+	path := os.Getenv("SSM_REVOCATION_PATH")
+	if path == "" {
+		return revokedMap, nil
+	}
+
+	response, err := ssmc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(path),
+		WithDecryption: aws.Bool(true),
+	})
+	var notFound *types.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return revokedMap, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(*response.Parameter.Value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			revokedMap[name] = true
 		}
 	}
+	log.Debug("Loaded ", len(revokedMap), " revoked API keys")
+	return revokedMap, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s expected duration like 5m, got %q", name, value)
+	}
+	return duration, nil
+}
+
+func envBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s expected true or false, got %q", name, value)
+	}
+	return b, nil
+}
 
-	log.Debug("Loaded ", len(keyMap), " API keys")
+func envNumber(name string) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%s expected non-negative number, got %q", name, value)
+	}
+	return number, nil
+}
 
-	cachedKeys = keyMap
-	return keyMap, nil
+func limitsFromEnv() (quota.Limits, error) {
+	rate, err := envNumber("RATE_LIMIT")
+	if err != nil {
+		return quota.Limits{}, err
+	}
+	burst, err := envNumber("RATE_BURST")
+	if err != nil {
+		return quota.Limits{}, err
+	}
+	dailyJobs, err := envNumber("DAILY_JOB_QUOTA")
+	if err != nil {
+		return quota.Limits{}, err
+	}
+	return quota.Limits{Rate: rate, Burst: int(burst), DailyJobs: int(dailyJobs)}, nil
 }
 
 func main() {
+	// Misconfigured keys fail the cold start, not each request. Not in init, so tests run without AWS.
+	_, err := keys.Get(context.TODO())
+	if err != nil {
+		log.Fatal(err)
+	}
 	lambda.Start(HandleRequest)
 }
 
@@ -89,33 +291,195 @@ func init() {
 	}
 
 	ssmc = ssm.NewFromConfig(cfg)
+
+	keysTTL, err := envDuration("KEYS_TTL", defaultKeysTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keysMaxStale, err := envDuration("KEYS_MAX_STALE", defaultKeysMaxStale)
+	if err != nil {
+		log.Fatal(err)
+	}
+	revocationTTL, err := envDuration("REVOCATION_TTL", defaultRevocationTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signatureSkew, err = envDuration("SIGNATURE_MAX_SKEW", defaultSignatureSkew)
+	if err != nil {
+		log.Fatal(err)
+	}
+	allowPlainKeys, err = envBool("ALLOW_PLAIN_KEYS", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys = newCache(keysTTL, keysMaxStale, loadKeys)
+	revoked = newCache(revocationTTL, keysMaxStale, loadRevoked)
+
+	defaultLimits, err = limitsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if table := os.Getenv("QUOTA_TABLE"); table != "" {
+		store := quota.NewDynamoStore(dynamodb.NewFromConfig(cfg), table)
+		limiter = quota.NewLimiter(store)
+		nonces = store
+	} else {
+		log.Warn("QUOTA_TABLE is not set, nonces of signed requests are kept only by this instance")
+		nonces = quota.NewMemoryStore()
+	}
+}
+
+func validate(ring keyRing, apiKey string) (bool, keyRef) {
+	ref, ok := ring.find(apiKey)
+	if !ok {
+		return false, keyRef{}
+	}
+	now := time.Now()
+	if !ref.expires.IsZero() && now.After(ref.expires) {
+		log.Info("rotated key expired: ", ref.name)
+		return false, ref
+	}
+	if ref.scopes.Expired(now) {
+		log.Info("key expired: ", ref.name)
+		return false, ref
+	}
+	return apikey.Verify(ring.pepper, ref.salt, apiKey, ref.hash), ref
 }
 
-func validate(keys map[string]string, apiKey string) (bool, string) {
-	name, ok := keys[apiKey]
-	return ok, name
+// REST API keeps header names as the client sent them
+func lowerHeaders(event events.APIGatewayCustomAuthorizerRequestTypeRequest) map[string]string {
+	headers := make(map[string]string, len(event.Headers))
+	for name, value := range event.Headers {
+		headers[strings.ToLower(name)] = value
+	}
+	return headers
 }
 
-func HandleRequest(ctx context.Context, event events.APIGatewayV2CustomAuthorizerV2Request) (events.APIGatewayV2CustomAuthorizerSimpleResponse, error) {
-	apiKey, ok := event.Headers["x-api-key"]
+// Resource path of the method, e.g. /unstable/v2/dmq/status/{jobId}
+func requestPath(event events.APIGatewayCustomAuthorizerRequestTypeRequest) string {
+	if event.RequestContext.ResourcePath != "" {
+		return event.RequestContext.ResourcePath
+	}
+	return event.Resource
+}
 
-	keyMap, err := getParameters(ctx)
+// Store failures let the request through, the API should not stop because of quota tracking
+func checkQuota(ctx context.Context, ref keyRef) string {
+	if limiter == nil {
+		return ""
+	}
+	reason, err := limiter.Allow(ctx, ref.name, defaultLimits.Merge(ref.limits))
 	if err != nil {
-		log.Fatal("error reading from parameter store. ", err)
+		log.Error("quota check failed, allowing request: ", err)
+		return ""
 	}
+	return reason
+}
 
-	valid := false
-	if ok {
-		var name string
-		valid, name = validate(keyMap, apiKey)
-		log.Info("key belongs to: ", name)
+// IAM policy for the invoked method only, the answer is not cached
+func policy(event events.APIGatewayCustomAuthorizerRequestTypeRequest, effect string) events.APIGatewayCustomAuthorizerPolicy {
+	return events.APIGatewayCustomAuthorizerPolicy{
+		Version: "2012-10-17",
+		Statement: []events.IAMPolicyStatement{{
+			Action:   []string{"execute-api:Invoke"},
+			Effect:   effect,
+			Resource: []string{event.MethodArn},
+		}},
+	}
+}
+
+func deny(event events.APIGatewayCustomAuthorizerRequestTypeRequest, principal string, reason string) events.APIGatewayCustomAuthorizerResponse {
+	if principal == "" {
+		principal = "anonymous"
+	}
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID:    principal,
+		PolicyDocument: policy(event, "Deny"),
+		Context: map[string]any{
+			"reason": reason,
+		},
+	}
+}
+
+// Passed to the integration as $context.authorizer.*, values are flat strings.
+// Unrestricted keys get "*", so an empty scope means the authorizer didn't run.
+// The authorizer doesn't see the body, spark compares it with bodySha256 of signed requests.
+// Spark counts started jobs against dailyJobs, the authorizer can't tell if the request starts one.
+func authContext(ref keyRef, headers map[string]string, signed bool) map[string]any {
+	stateMachines := strings.Join(ref.scopes.StateMachines, ",")
+	if stateMachines == "" {
+		stateMachines = "*"
+	}
+	values := map[string]any{
+		"keyName":       ref.name,
+		"routes":        strings.Join(ref.scopes.Routes, ","),
+		"stateMachines": stateMachines,
+		"authMode":      "api-key",
+		"dailyJobs":     strconv.Itoa(defaultLimits.Merge(ref.limits).DailyJobs),
+	}
+	if signed {
+		values["authMode"] = "signature"
+		values["bodySha256"] = headers[signature.HeaderBodySha256]
+	}
+	return values
+}
+
+func HandleRequest(ctx context.Context, event events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	ring, err := keys.Get(ctx)
+	if err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, errors.Join(errors.New("error reading keys from parameter store"), err)
+	}
+	revokedMap, err := revoked.Get(ctx)
+	if err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, errors.Join(errors.New("error reading revocation list from parameter store"), err)
+	}
+
+	// Both modes can be used by one key, the signature is preferred when present
+	headers := lowerHeaders(event)
+	var ref keyRef
+	_, signed := headers[signature.HeaderSignature]
+	if signed {
+		var reason string
+		ref, reason, err = verifySigned(ctx, ring, event, headers)
+		if err != nil {
+			return events.APIGatewayCustomAuthorizerResponse{}, err
+		}
+		if reason != "" {
+			return deny(event, ref.name, reason), nil
+		}
 	} else {
-		log.Info("request doesn't have x-api-key header")
+		apiKey, ok := headers["x-api-key"]
+		if !ok {
+			log.Info("request doesn't have x-api-key header")
+			return deny(event, "", reasonInvalidKey), nil
+		}
+
+		var valid bool
+		valid, ref = validate(ring, apiKey)
+		log.Info("key belongs to: ", ref.name)
+		if !valid {
+			return deny(event, "", reasonInvalidKey), nil
+		}
+	}
+
+	if revokedMap[ref.name] {
+		log.Info("key is revoked: ", ref.name)
+		return deny(event, ref.name, reasonRevoked), nil
+	}
+	if !ref.scopes.AllowsRoute(requestPath(event)) {
+		log.Info("key ", ref.name, " is not allowed to use route ", requestPath(event))
+		return deny(event, ref.name, reasonRouteNotAllowed), nil
+	}
+	if reason := checkQuota(ctx, ref); reason != "" {
+		log.Info("key ", ref.name, " exceeded limits: ", reason)
+		return deny(event, ref.name, reason), nil
 	}
 
-	log.Info("request validity: ", valid)
+	log.Info("request is valid")
 
-	return events.APIGatewayV2CustomAuthorizerSimpleResponse{
-		IsAuthorized: valid,
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID:    ref.name,
+		PolicyDocument: policy(event, "Allow"),
+		Context:        authContext(ref, headers, signed),
 	}, nil
 }
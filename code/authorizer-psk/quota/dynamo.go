@@ -0,0 +1,120 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Unused buckets are removed by the table TTL on attribute expires
+const bucketRetention = 24 * time.Hour
+
+// Store shared by all authorizer instances.
+// The table has string partition key pk and TTL enabled on attribute expires.
+type DynamoStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func NewDynamoStore(client *dynamodb.Client, table string) *DynamoStore {
+	return &DynamoStore{client: client, table: table}
+}
+
+func (s *DynamoStore) GetBucket(ctx context.Context, key string) (Bucket, error) {
+	response, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Bucket{}, err
+	}
+	if response.Item == nil {
+		return Bucket{}, nil
+	}
+
+	tokens, err := numberAttr(response.Item, "tokens")
+	if err != nil {
+		return Bucket{}, err
+	}
+	updated, err := numberAttr(response.Item, "updated")
+	if err != nil {
+		return Bucket{}, err
+	}
+	version, err := numberAttr(response.Item, "version")
+	if err != nil {
+		return Bucket{}, err
+	}
+	return Bucket{
+		Tokens:  tokens,
+		Updated: time.UnixMilli(int64(updated)),
+		Version: int64(version),
+	}, nil
+}
+
+func (s *DynamoStore) PutBucket(ctx context.Context, key string, bucket Bucket) (bool, error) {
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"pk":      &types.AttributeValueMemberS{Value: key},
+			"tokens":  numberValue(bucket.Tokens),
+			"updated": numberValue(float64(bucket.Updated.UnixMilli())),
+			"version": numberValue(float64(bucket.Version + 1)),
+			"expires": numberValue(float64(bucket.Updated.Add(bucketRetention).Unix())),
+		},
+	}
+	if bucket.Version == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(pk)")
+	} else {
+		input.ConditionExpression = aws.String("version = :version")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":version": numberValue(float64(bucket.Version)),
+		}
+	}
+
+	_, err := s.client.PutItem(ctx, input)
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *DynamoStore) Increment(ctx context.Context, key string, max int, expires time.Time) (bool, error) {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.table),
+		Key:                 map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: key}},
+		UpdateExpression:    aws.String("ADD #count :one SET expires = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(#count) OR #count < :max"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": "count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":     numberValue(1),
+			":max":     numberValue(float64(max)),
+			":expires": numberValue(float64(expires.Unix())),
+		},
+	})
+	var limitReached *types.ConditionalCheckFailedException
+	if errors.As(err, &limitReached) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func numberValue(n float64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatFloat(n, 'f', -1, 64)}
+}
+
+func numberAttr(item map[string]types.AttributeValue, name string) (float64, error) {
+	attr, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, errors.New("quota item has no number attribute " + name)
+	}
+	return strconv.ParseFloat(attr.Value, 64)
+}
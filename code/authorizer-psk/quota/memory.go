@@ -0,0 +1,64 @@
+package quota
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store kept in the memory of one instance, for tests and local runs
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]Bucket
+	counters map[string]counter
+	now      func() time.Time
+}
+
+type counter struct {
+	count   int
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]Bucket),
+		counters: make(map[string]counter),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) GetBucket(ctx context.Context, key string) (Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[key], nil
+}
+
+func (s *MemoryStore) PutBucket(ctx context.Context, key string, bucket Bucket) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[key].Version != bucket.Version {
+		return false, nil
+	}
+	bucket.Version++
+	s.buckets[key] = bucket
+	return true, nil
+}
+
+// Expired counters are removed like by the TTL of the table, so nonces don't pile up in a long running instance
+func (s *MemoryStore) Increment(ctx context.Context, key string, max int, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, c := range s.counters {
+		if !now.Before(c.expires) {
+			delete(s.counters, k)
+		}
+	}
+
+	c := s.counters[key]
+	if c.count >= max {
+		return false, nil
+	}
+	s.counters[key] = counter{count: c.count + 1, expires: expires}
+	return true, nil
+}
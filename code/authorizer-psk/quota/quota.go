@@ -0,0 +1,118 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	ReasonRateLimited = "rate-limited"
+
+	// Conflicting updates of one bucket, e.g. from parallel authorizer instances
+	maxBucketRetries = 3
+)
+
+// Zero value means no limit
+type Limits struct {
+	// Requests per second refilled into the bucket
+	Rate float64 `json:"rate,omitempty"`
+	// Size of the bucket, requests which can come at once
+	Burst int `json:"burst,omitempty"`
+	// Jobs started per UTC day, passed to spark which counts them
+	DailyJobs int `json:"dailyJobs,omitempty"`
+}
+
+// Fields set in the override replace the defaults
+func (l Limits) Merge(override *Limits) Limits {
+	if override == nil {
+		return l
+	}
+	if override.Rate != 0 {
+		l.Rate = override.Rate
+	}
+	if override.Burst != 0 {
+		l.Burst = override.Burst
+	}
+	if override.DailyJobs != 0 {
+		l.DailyJobs = override.DailyJobs
+	}
+	return l
+}
+
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+	// Zero for a bucket which isn't stored yet
+	Version int64
+}
+
+type Store interface {
+	// Missing bucket is returned as zero Bucket
+	GetBucket(ctx context.Context, key string) (Bucket, error)
+	// Saves the bucket with version+1 if it still has the version. False when somebody else saved it first.
+	PutBucket(ctx context.Context, key string, bucket Bucket) (bool, error)
+	// Adds one to the counter unless it reached max. False when it is reached.
+	Increment(ctx context.Context, key string, max int, expires time.Time) (bool, error)
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Checks the rate limit of the key. Reason is empty when the request is allowed.
+// Daily jobs are counted by spark, only jobs which really started count.
+func (l *Limiter) Allow(ctx context.Context, keyName string, limits Limits) (string, error) {
+	if limits.Rate <= 0 {
+		return "", nil
+	}
+	ok, err := l.takeToken(ctx, keyName, limits, l.now())
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return ReasonRateLimited, nil
+	}
+	return "", nil
+}
+
+func (l *Limiter) takeToken(ctx context.Context, keyName string, limits Limits, now time.Time) (bool, error) {
+	burst := float64(max(limits.Burst, 1))
+	key := "bucket#" + keyName
+	for range maxBucketRetries {
+		bucket, err := l.store.GetBucket(ctx, key)
+		if err != nil {
+			return false, errors.Join(errors.New("error reading rate limit bucket"), err)
+		}
+		bucket = refill(bucket, limits.Rate, burst, now)
+		if bucket.Tokens < 1 {
+			return false, nil
+		}
+		bucket.Tokens--
+		saved, err := l.store.PutBucket(ctx, key, bucket)
+		if err != nil {
+			return false, errors.Join(errors.New("error saving rate limit bucket"), err)
+		}
+		if saved {
+			return true, nil
+		}
+	}
+	return false, errors.New("rate limit bucket is updated concurrently, giving up")
+}
+
+// New bucket starts full
+func refill(bucket Bucket, rate, burst float64, now time.Time) Bucket {
+	if bucket.Version == 0 {
+		bucket.Tokens = burst
+	} else if elapsed := now.Sub(bucket.Updated).Seconds(); elapsed > 0 {
+		bucket.Tokens = math.Min(burst, bucket.Tokens+elapsed*rate)
+	}
+	bucket.Updated = now
+	return bucket
+}
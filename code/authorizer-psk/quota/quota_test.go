@@ -0,0 +1,77 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(now *time.Time) *Limiter {
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+	limits := Limits{Rate: 1, Burst: 2}
+
+	for i := range 2 {
+		reason, err := limiter.Allow(ctx, "GR/cz", limits)
+		if err != nil || reason != "" {
+			t.Fatalf("request %d denied: %q %v", i, reason, err)
+		}
+	}
+	reason, _ := limiter.Allow(ctx, "GR/cz", limits)
+	if reason != ReasonRateLimited {
+		t.Errorf("expected rate limit after burst, got %q", reason)
+	}
+
+	reason, _ = limiter.Allow(ctx, "GR/demo", limits)
+	if reason != "" {
+		t.Errorf("other key shares the bucket, got %q", reason)
+	}
+
+	now = now.Add(time.Second)
+	reason, _ = limiter.Allow(ctx, "GR/cz", limits)
+	if reason != "" {
+		t.Errorf("bucket should refill, got %q", reason)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	if ok, _ := store.Increment(ctx, "nonce#GR/cz#a", 1, now.Add(5*time.Minute)); !ok {
+		t.Fatal("first nonce rejected")
+	}
+	if ok, _ := store.Increment(ctx, "nonce#GR/cz#a", 1, now.Add(5*time.Minute)); ok {
+		t.Error("replayed nonce accepted")
+	}
+	store.Increment(ctx, "nonce#GR/cz#b", 1, now.Add(10*time.Minute))
+
+	now = now.Add(5 * time.Minute)
+	store.Increment(ctx, "nonce#GR/cz#c", 1, now.Add(5*time.Minute))
+	if len(store.counters) != 2 {
+		t.Errorf("expired counter not evicted, got %v", store.counters)
+	}
+	if ok, _ := store.Increment(ctx, "nonce#GR/cz#a", 1, now.Add(5*time.Minute)); !ok {
+		t.Error("expired counter still counts")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	defaults := Limits{Rate: 1, Burst: 10, DailyJobs: 50}
+	merged := defaults.Merge(&Limits{DailyJobs: 5})
+	if merged != (Limits{Rate: 1, Burst: 10, DailyJobs: 5}) {
+		t.Errorf("unexpected limits %+v", merged)
+	}
+	if defaults.Merge(nil) != defaults {
+		t.Error("nil override changed limits")
+	}
+}
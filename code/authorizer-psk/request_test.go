@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"authorizer/apikey"
+	"authorizer/quota"
+)
+
+func TestAuthContext(t *testing.T) {
+	defaultLimits = quota.Limits{DailyJobs: 50}
+	defer func() { defaultLimits = quota.Limits{} }()
+
+	unrestricted := authContext(keyRef{name: "GR/cz"}, nil, false)
+	if unrestricted["stateMachines"] != "*" || unrestricted["authMode"] != "api-key" || unrestricted["dailyJobs"] != "50" {
+		t.Errorf("unrestricted key context %v", unrestricted)
+	}
+
+	scoped := keyRef{
+		name:   "GR/cz",
+		scopes: apikey.Scopes{StateMachines: []string{"DMQs-Make-*", "VideoRender-*"}},
+		limits: &quota.Limits{DailyJobs: 5},
+	}
+	signed := authContext(scoped, map[string]string{"x-content-sha256": "abc"}, true)
+	if signed["stateMachines"] != "DMQs-Make-*,VideoRender-*" || signed["authMode"] != "signature" || signed["bodySha256"] != "abc" || signed["dailyJobs"] != "5" {
+		t.Errorf("signed key context %v", signed)
+	}
+}
+
+func TestRequestOfRestApi(t *testing.T) {
+	event := events.APIGatewayCustomAuthorizerRequestTypeRequest{
+		MethodArn:  "arn:aws:execute-api:eu-central-1:123456789012:abc/api/POST/unstable/v2/dmq/make",
+		Path:       "/unstable/v2/dmq/make",
+		HTTPMethod: "POST",
+		Headers:    map[string]string{"X-Api-Key": "isk_abc", "X-Signature": "sig"},
+		MultiValueQueryStringParameters: map[string][]string{
+			"b": {"2"},
+			"a": {"1 2"},
+		},
+		RequestContext: events.APIGatewayCustomAuthorizerRequestTypeRequestContext{
+			ResourcePath: "/unstable/v2/dmq/make",
+		},
+	}
+
+	headers := lowerHeaders(event)
+	if headers["x-api-key"] != "isk_abc" || headers["x-signature"] != "sig" {
+		t.Errorf("headers are not lower case %v", headers)
+	}
+	if got := signedPath(event); got != "/unstable/v2/dmq/make?a=1+2&b=2" {
+		t.Errorf("signedPath() = %q", got)
+	}
+	if got := requestPath(event); got != "/unstable/v2/dmq/make" {
+		t.Errorf("requestPath() = %q", got)
+	}
+
+	response := deny(event, "", reasonInvalidKey)
+	statement := response.PolicyDocument.Statement[0]
+	if statement.Effect != "Deny" || statement.Resource[0] != event.MethodArn || response.Context["reason"] != reasonInvalidKey {
+		t.Errorf("unexpected deny %+v", response)
+	}
+}
+
+func TestKeyRingFind(t *testing.T) {
+	pepper := []byte("pepper")
+	ring := keyRing{pepper: pepper, byHash: make(map[string]keyRef)}
+	ring.add(keyRef{name: "GR/legacy", hash: apikey.Hash(pepper, "", "old-key")})
+	ring.add(keyRef{name: "GR/cz", hash: apikey.Hash(pepper, "s1", "cz-key"), salt: "s1"})
+	ring.add(keyRef{name: "GR/demo", hash: apikey.Hash(pepper, "s2", "demo-key"), salt: "s2"})
+
+	type tc struct {
+		key  string
+		want string
+	}
+	tests := []tc{
+		{"old-key", "GR/legacy"},
+		{"cz-key", "GR/cz"},
+		{"demo-key", "GR/demo"},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			ref, ok := ring.find(tt.key)
+			if ok != (tt.want != "") || ref.name != tt.want {
+				t.Errorf("find(%s) = %s %v, want %s", tt.key, ref.name, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderKeyId      = "x-key-id"
+	HeaderSignature  = "x-signature"
+	HeaderTimestamp  = "x-timestamp"
+	HeaderNonce      = "x-nonce"
+	HeaderBodySha256 = "x-content-sha256"
+
+	// Hash of an empty body, used when the header is missing
+	emptyBodySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Signed request parts, one per line
+type Request struct {
+	Method     string
+	Path       string
+	Timestamp  string
+	Nonce      string
+	BodySha256 string
+}
+
+func (r Request) Canonical() string {
+	bodyHash := strings.ToLower(r.BodySha256)
+	if bodyHash == "" {
+		bodyHash = emptyBodySha256
+	}
+	return strings.Join([]string{strings.ToUpper(r.Method), r.Path, r.Timestamp, r.Nonce, bodyHash}, "\n")
+}
+
+// Hex HMAC-SHA256 of the canonical request
+func Sign(secret string, r Request) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.Canonical()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret string, r Request, signature string) bool {
+	expected := Sign(secret, r)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// Timestamp is unix seconds, it must be within skew from now in both directions
+func CheckTimestamp(timestamp string, now time.Time, skew time.Duration) (time.Time, error) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp %q is not unix seconds", timestamp)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-skew)) || signedAt.After(now.Add(skew)) {
+		return signedAt, fmt.Errorf("timestamp %s is out of allowed skew %s", signedAt.UTC().Format(time.RFC3339), skew)
+	}
+	return signedAt, nil
+}
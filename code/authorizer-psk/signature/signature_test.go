@@ -0,0 +1,59 @@
+package signature
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	r := Request{
+		Method:     "post",
+		Path:       "/unstable/v2/dmq/make",
+		Timestamp:  "1767225600",
+		Nonce:      "3f2a",
+		BodySha256: "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08",
+	}
+	sig := Sign("secret", r)
+	if !Verify("secret", r, sig) {
+		t.Fatal("valid signature rejected")
+	}
+	if Verify("other", r, sig) {
+		t.Error("signature accepted with another secret")
+	}
+
+	tampered := r
+	tampered.Path = "/unstable/v2/video-render/reel"
+	if Verify("secret", tampered, sig) {
+		t.Error("signature accepted for another path")
+	}
+}
+
+func TestCanonicalEmptyBody(t *testing.T) {
+	r := Request{Method: "GET", Path: "/a", Timestamp: "1", Nonce: "n"}
+	want := "GET\n/a\n1\nn\n" + emptyBodySha256
+	if got := r.Canonical(); got != want {
+		t.Errorf("Canonical() = %q, want %q", got, want)
+	}
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	skew := 5 * time.Minute
+	cases := []struct {
+		timestamp string
+		ok        bool
+	}{
+		{"1767225600", true},
+		{"1767225300", true},
+		{"1767225299", false},
+		{"1767225900", true},
+		{"1767225901", false},
+		{"2026-01-01", false},
+	}
+	for _, c := range cases {
+		_, err := CheckTimestamp(c.timestamp, now, skew)
+		if (err == nil) != c.ok {
+			t.Errorf("CheckTimestamp(%q) error = %v, want ok %v", c.timestamp, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"authorizer/signature"
+)
+
+const (
+	defaultSignatureSkew = 5 * time.Minute
+
+	reasonInvalidSignature = "invalid-signature"
+	reasonReplayed         = "replayed"
+)
+
+// Path with the query. REST API doesn't pass the raw query, it is rebuilt with sorted parameters.
+func signedPath(event events.APIGatewayCustomAuthorizerRequestTypeRequest) string {
+	query := url.Values(event.MultiValueQueryStringParameters).Encode()
+	if query == "" {
+		return event.Path
+	}
+	return event.Path + "?" + query
+}
+
+// Request signed with the shared secret of the key in x-key-id. Empty reason means valid.
+func verifySigned(ctx context.Context, ring keyRing, event events.APIGatewayCustomAuthorizerRequestTypeRequest, headers map[string]string) (keyRef, string, error) {
+	ref, ok := ring.byName[headers[signature.HeaderKeyId]]
+	if !ok {
+		log.Info("no signing secret for key id: ", headers[signature.HeaderKeyId])
+		return keyRef{}, reasonInvalidKey, nil
+	}
+	log.Info("signed by key: ", ref.name)
+
+	now := time.Now()
+	if ref.scopes.Expired(now) {
+		log.Info("key expired: ", ref.name)
+		return ref, reasonInvalidKey, nil
+	}
+	signedAt, err := signature.CheckTimestamp(headers[signature.HeaderTimestamp], now, signatureSkew)
+	if err != nil {
+		log.Info("rejected signature: ", err)
+		return ref, reasonInvalidSignature, nil
+	}
+	nonce := headers[signature.HeaderNonce]
+	if nonce == "" {
+		log.Info("signed request doesn't have ", signature.HeaderNonce, " header")
+		return ref, reasonInvalidSignature, nil
+	}
+
+	request := signature.Request{
+		Method:     event.HTTPMethod,
+		Path:       signedPath(event),
+		Timestamp:  headers[signature.HeaderTimestamp],
+		Nonce:      nonce,
+		BodySha256: headers[signature.HeaderBodySha256],
+	}
+	if !signature.Verify(ref.signingSecret, request, headers[signature.HeaderSignature]) {
+		log.Info("signature doesn't match for key: ", ref.name)
+		return ref, reasonInvalidSignature, nil
+	}
+
+	// Checked after the signature, so unsigned requests can't use up nonces.
+	// The nonce is needed only while the timestamp is accepted.
+	fresh, err := nonces.Increment(ctx, "nonce#"+ref.name+"#"+nonce, 1, signedAt.Add(signatureSkew))
+	if err != nil {
+		return ref, "", errors.Join(errors.New("error saving nonce"), err)
+	}
+	if !fresh {
+		log.Info("replayed nonce ", nonce, " of key: ", ref.name)
+		return ref, reasonReplayed, nil
+	}
+	return ref, "", nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Longest date range of one invocation, a month of quotes with margin
+const maxBatchDays = 62
+
+type Upload struct {
+	S3Key string `json:"s3Key"`
+	// Date of the quote, same format as Event.Date
+	Date string `json:"date"`
+}
+
+// Result for one date. Error is set instead of failing the whole batch, so one missing photo doesn't stop the other dates.
+type BatchItem struct {
+	Date        string `json:"date"`
+	S3Key       string `json:"s3Key"`
+	ImageId     string `json:"imageId,omitempty"`
+	Strategy    string `json:"strategy,omitempty"`
+	DriveFileId string `json:"driveFileId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (e Event) isBatch() bool {
+	return e.DateFrom != "" || e.DateTo != "" || len(e.Uploads) > 0
+}
+
+func dateRange(from string, to string, timeZone string) ([]time.Time, error) {
+	if from == "" || to == "" {
+		return nil, errors.New("Both dateFrom and dateTo are required")
+	}
+	start, err := parseDate(from, timeZone)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseDate(to, timeZone)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("dateTo %s is before dateFrom %s", to, from)
+	}
+
+	var dates []time.Time
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		dates = append(dates, day)
+		if len(dates) > maxBatchDays {
+			return nil, fmt.Errorf("Date range is longer than %d days", maxBatchDays)
+		}
+	}
+	return dates, nil
+}
+
+func (r *Response) add(item BatchItem, err error) {
+	if err != nil {
+		log.Warnf("date=%s failed: %v", item.Date, err)
+		item.Error = err.Error()
+		r.Failed++
+	}
+	r.Items = append(r.Items, item)
+}
+
+func handleBatch(ctx context.Context, event Event) (Response, error) {
+	response := Response{Items: []BatchItem{}}
+
+	log.Infof("batch direction=%s", event.Direction)
+	switch event.Direction {
+	case "driveToS3":
+		dates, err := dateRange(event.DateFrom, event.DateTo, event.TimeZone)
+		if err != nil {
+			return response, err
+		}
+		sel, err := prepareSelection(event)
+		if err != nil {
+			return response, err
+		}
+
+		for _, date := range dates {
+			item := BatchItem{
+				Date:  date.Format(plainDateLayout),
+				S3Key: path.Join(event.S3Key, date.Format(plainDateLayout)),
+			}
+			item.ImageId, item.Strategy, err = copyImage(ctx, event, sel, date, item.S3Key)
+			response.add(item, err)
+		}
+		response.Unparsed = sel.Unparsed
+
+	case "s3ToDrive":
+		for _, upload := range event.Uploads {
+			item := BatchItem{Date: upload.Date, S3Key: upload.S3Key}
+			date, err := parseDate(upload.Date, event.TimeZone)
+			if err == nil {
+				item.Date = date.Format(plainDateLayout)
+				item.DriveFileId, err = uploadResult(ctx, event, upload.S3Key, date)
+			}
+			response.add(item, err)
+		}
+
+	default:
+		return response, fmt.Errorf("Unknown direction: %s", event.Direction)
+	}
+
+	log.Infof("batch done items=%d failed=%d", len(response.Items), response.Failed)
+	return response, nil
+}
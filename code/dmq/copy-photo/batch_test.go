@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDateRange(t *testing.T) {
+	type tc struct {
+		name     string
+		from     string
+		to       string
+		timeZone string
+		first    string
+		last     string
+		days     int
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "single day", from: "2025-05-28", to: "2025-05-28", first: "2025-05-28", last: "2025-05-28", days: 1},
+		{name: "month", from: "2025-02-01", to: "2025-02-28", first: "2025-02-01", last: "2025-02-28", days: 28},
+		{name: "leap day", from: "2024-02-28", to: "2024-03-01", first: "2024-02-28", last: "2024-03-01", days: 3},
+		{name: "across year", from: "2025-12-30", to: "2026-01-02", first: "2025-12-30", last: "2026-01-02", days: 4},
+		{name: "62 days is maximum", from: "2025-01-01", to: "2025-03-03", first: "2025-01-01", last: "2025-03-03", days: 62},
+		{name: "63 days", from: "2025-01-01", to: "2025-03-04", wantErr: true},
+		{name: "whole year", from: "2025-01-01", to: "2025-12-31", wantErr: true},
+		{name: "timestamps in time zone", from: "2025-05-27T20:00:00Z", to: "2025-05-29T20:00:00Z", timeZone: "Asia/Kolkata", first: "2025-05-28", last: "2025-05-30", days: 3},
+		{name: "reversed", from: "2025-05-28", to: "2025-05-27", wantErr: true},
+		{name: "missing dateTo", from: "2025-05-28", wantErr: true},
+		{name: "invalid date", from: "2025-05-28", to: "tomorrow", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := dateRange(test.from, test.to, test.timeZone)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("dateRange(%q, %q) returned %d days, want error", test.from, test.to, len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dateRange(%q, %q) error: %v", test.from, test.to, err)
+			}
+			if len(got) != test.days {
+				t.Fatalf("dateRange(%q, %q) returned %d days, want %d", test.from, test.to, len(got), test.days)
+			}
+			first, last := got[0].Format(plainDateLayout), got[len(got)-1].Format(plainDateLayout)
+			if first != test.first || last != test.last {
+				t.Errorf("dateRange(%q, %q) = %s..%s, want %s..%s", test.from, test.to, first, last, test.first, test.last)
+			}
+		})
+	}
+}
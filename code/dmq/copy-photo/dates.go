@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	// Lambda runtime image has no zoneinfo
+	_ "time/tzdata"
+)
+
+const (
+	plainDateLayout = "2006-01-02"
+	// Timestamp without offset, needs timeZone to be meaningful
+	localTimeLayout = "2006-01-02T15:04:05.999999999"
+)
+
+// Resolves the calendar day of the quote. Returned time is midnight UTC of that day.
+//
+//   - "2025-05-28" is used as is, regardless of timeZone
+//   - "2025-05-28T23:00:00-01:00" is converted to timeZone; without timeZone the day in its own offset is used
+//   - "2025-05-28T23:00:00" (no offset) requires timeZone
+func parseDate(value string, timeZone string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("Date is required")
+	}
+
+	var loc *time.Location
+	if timeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Unknown time zone %q, expected IANA name like Asia/Kolkata", timeZone)
+		}
+	}
+
+	day, err := time.Parse(plainDateLayout, value)
+	if err == nil {
+		return day, nil
+	}
+
+	instant, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		if loc != nil {
+			instant = instant.In(loc)
+		}
+		return midnight(instant), nil
+	}
+
+	local, err := time.Parse(localTimeLayout, value)
+	if err == nil {
+		if loc == nil {
+			return time.Time{}, fmt.Errorf("Date %q has no offset, add timeZone or use YYYY-MM-DD", value)
+		}
+		return midnight(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Date %q is neither YYYY-MM-DD nor RFC 3339 timestamp", value)
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	type tc struct {
+		name     string
+		value    string
+		timeZone string
+		expected string
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "plain date", value: "2025-05-28", expected: "2025-05-28"},
+		{name: "plain date ignores time zone", value: "2025-05-28", timeZone: "Pacific/Kiritimati", expected: "2025-05-28"},
+		{name: "timestamp in own offset", value: "2025-05-28T23:00:00-01:00", expected: "2025-05-28"},
+		{name: "timestamp moved to next day", value: "2025-05-28T23:00:00-01:00", timeZone: "Asia/Kolkata", expected: "2025-05-29"},
+		{name: "timestamp moved to previous day", value: "2025-05-28T01:00:00Z", timeZone: "America/Los_Angeles", expected: "2025-05-27"},
+		{name: "timestamp with fraction", value: "2025-05-28T18:29:59.999Z", timeZone: "Asia/Kolkata", expected: "2025-05-28"},
+		{name: "time zone at midnight", value: "2025-05-28T18:30:00Z", timeZone: "Asia/Kolkata", expected: "2025-05-29"},
+		{name: "UTC time zone", value: "2025-12-31T23:59:59+05:30", timeZone: "UTC", expected: "2025-12-31"},
+		{name: "local time with time zone", value: "2025-05-28T23:00:00", timeZone: "Asia/Kolkata", expected: "2025-05-28"},
+		{name: "local time without time zone", value: "2025-05-28T23:00:00", wantErr: true},
+		{name: "unknown time zone", value: "2025-05-28", timeZone: "India/Mumbai", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+		{name: "other format", value: "28.05.2025", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseDate(test.value, test.timeZone)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parseDate(%q, %q) = %v, want error", test.value, test.timeZone, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDate(%q, %q) error: %v", test.value, test.timeZone, err)
+			}
+			if got.Location() != time.UTC || got.Format(time.RFC3339) != test.expected+"T00:00:00Z" {
+				t.Errorf("parseDate(%q, %q) = %v, want midnight UTC of %s", test.value, test.timeZone, got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"google.golang.org/api/drive/v3"
+
+	"lambdalib/random"
+)
+
+const (
+	// Image for the requested date was found, no fallback used
+	strategyDate         = "date"
+	strategyPreviousDay  = "previousDay"
+	strategyRandomUnused = "randomUnused"
+	strategyDefault      = "default"
+
+	defaultUsedKey = "dmq/fallback-used.json"
+	// Attempts to update the used list when other jobs change it meanwhile
+	maxUsedWrites = 5
+)
+
+// Strategies tried in order when there is no image for the requested date
+type Fallback struct {
+	// Any of "previousDay", "randomUnused", "default". Empty means the job fails without an image.
+	Chain []string `json:"chain,omitempty"`
+	// Folder with images for "randomUnused"
+	FolderId string `json:"folderId,omitempty"`
+	// Key in s3Bucket of JSON list with IDs already used by "randomUnused"
+	UsedKey string `json:"usedKey,omitempty"`
+	// Image for "default"
+	DefaultFileId string `json:"defaultFileId,omitempty"`
+}
+
+func (f Fallback) validate() error {
+	for _, strategy := range f.Chain {
+		switch strategy {
+		case strategyPreviousDay:
+		case strategyRandomUnused:
+			if f.FolderId == "" {
+				return errors.New("Fallback randomUnused requires folderId")
+			}
+		case strategyDefault:
+			if f.DefaultFileId == "" {
+				return errors.New("Fallback default requires defaultFileId")
+			}
+		default:
+			return fmt.Errorf("Unknown fallback strategy: %s", strategy)
+		}
+	}
+	return nil
+}
+
+// Nearest previous day in the same month folder
+func (s *selector) previousDay(ctx context.Context, driveId string, monthId string, day int) (string, error) {
+	if monthId == "" {
+		return "", errors.New("Month folder not found")
+	}
+	for d := day - 1; d >= 1; d-- {
+		img, err := s.selectFileByDay(ctx, driveId, monthId, d)
+		if err == nil {
+			log.Infof("Using image of previous day %d", d)
+			return img, nil
+		}
+		if !isNotFound(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("No image before day %d in %s", day, monthId)
+}
+
+// Random image from the fallback folder which wasn't used yet. The used list is kept in S3.
+// Concurrent jobs update the list with conditional writes, so no image is used twice.
+func randomUnused(ctx context.Context, driveId string, fallback Fallback, s3Bucket string) (string, error) {
+	usedKey := fallback.UsedKey
+	if usedKey == "" {
+		usedKey = defaultUsedKey
+	}
+
+	// Not cached, a warm Lambda would miss new images and pick deleted ones
+	files, err := resolver.FreshChildren(ctx, driveId, fallback.FolderId, "")
+	if err != nil {
+		return "", err
+	}
+	// Folder can contain subfolders and documents too
+	var images []*drive.File
+	for _, file := range files {
+		if strings.HasPrefix(file.MimeType, "image/") {
+			images = append(images, file)
+		}
+	}
+	rand := random.NewRandom()
+	random.Shuffle(rand, images)
+
+	for range maxUsedWrites {
+		used, etag, err := readUsed(ctx, s3Bucket, usedKey)
+		if err != nil {
+			return "", err
+		}
+
+		index := slices.IndexFunc(images, func(image *drive.File) bool {
+			return !slices.Contains(used, image.Id)
+		})
+		if index < 0 {
+			return "", fmt.Errorf("All %d images in fallback folder %s were already used", len(images), fallback.FolderId)
+		}
+		imageId := images[index].Id
+
+		written, err := writeUsed(ctx, s3Bucket, usedKey, append(used, imageId), etag)
+		if err != nil {
+			return "", err
+		}
+		if written {
+			return imageId, nil
+		}
+		log.Debugf("Used fallback images s3=%s key=%s changed, retrying", s3Bucket, usedKey)
+	}
+	return "", fmt.Errorf("Used fallback images s3=%s key=%s changed %d times in a row", s3Bucket, usedKey, maxUsedWrites)
+}
+
+// Returns the used list with its ETag. Empty ETag means the list doesn't exist yet.
+func readUsed(ctx context.Context, s3Bucket string, usedKey string) ([]string, string, error) {
+	obj, err := s3c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s3Bucket,
+		Key:    &usedKey,
+	})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return []string{}, "", nil
+	}
+	if err != nil {
+		return nil, "", errors.Join(fmt.Errorf("Error reading used fallback images s3=%s key=%s", s3Bucket, usedKey), err)
+	}
+	defer obj.Body.Close()
+
+	used := []string{}
+	err = json.NewDecoder(obj.Body).Decode(&used)
+	if err != nil {
+		return nil, "", errors.Join(fmt.Errorf("Error decoding used fallback images s3=%s key=%s", s3Bucket, usedKey), err)
+	}
+	return used, aws.ToString(obj.ETag), nil
+}
+
+// Writes the list only if it wasn't changed since it was read. Returns false when it was.
+func writeUsed(ctx context.Context, s3Bucket string, usedKey string, used []string, etag string) (bool, error) {
+	body, err := json.Marshal(used)
+	if err != nil {
+		return false, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket: &s3Bucket,
+		Key:    &usedKey,
+		Body:   bytes.NewReader(body),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = &etag
+	}
+	_, err = s3c.PutObject(ctx, input)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Join(fmt.Errorf("Error saving used fallback images s3=%s key=%s", s3Bucket, usedKey), err)
+	}
+	return true, nil
+}
+
+// Finds image for the date and walks the fallback chain when there is none. Returns image ID and used strategy.
+func (s *selector) selectImage(ctx context.Context, event Event, date time.Time) (string, string, error) {
+	monthId, imageId, err := s.getImageByDate(ctx, event.DriveId, event.DriveFolderId, date)
+	if err == nil {
+		return imageId, strategyDate, nil
+	}
+	if !isNotFound(err) {
+		return "", "", err
+	}
+	errs := []error{err}
+
+	for _, strategy := range event.Fallback.Chain {
+		log.Infof("No image for date, trying fallback %s", strategy)
+		switch strategy {
+		case strategyPreviousDay:
+			imageId, err = s.previousDay(ctx, event.DriveId, monthId, date.Day())
+		case strategyRandomUnused:
+			imageId, err = randomUnused(ctx, event.DriveId, event.Fallback, event.S3Bucket)
+		case strategyDefault:
+			imageId, err = event.Fallback.DefaultFileId, nil
+		}
+		if err == nil {
+			return imageId, strategy, nil
+		}
+		log.Warnf("Fallback %s failed: %v", strategy, err)
+		errs = append(errs, fmt.Errorf("Fallback %s: %w", strategy, err))
+	}
+	return "", "", errors.Join(errs...)
+}
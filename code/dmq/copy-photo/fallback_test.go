@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"lambdalib/drivePath"
+)
+
+var parentQuery = regexp.MustCompile(`'([^']*)' in parents`)
+
+// Photo archive with images for May 3 and May 10 2025, and a fallback folder with one image
+func archiveServer(t *testing.T) *httptest.Server {
+	folders := map[string][]*drive.File{
+		"archive": {
+			{Id: "y2025", Name: "SQ Photos 2025", MimeType: drivePath.FolderMime},
+		},
+		"y2025": {
+			{Id: "m05", Name: "05 May 2025", MimeType: drivePath.FolderMime},
+			{Id: "m06", Name: "06 Jun 2025", MimeType: drivePath.FolderMime},
+		},
+		"m05": {
+			{Id: "img3", Name: "May-3-20250503_KSW_1.jpg", MimeType: "image/jpeg"},
+			{Id: "img10", Name: "May-10-20250510_KSW_2.jpg", MimeType: "image/jpeg"},
+		},
+		"m06": {},
+		"fallback": {
+			{Id: "fbSub", Name: "Old", MimeType: drivePath.FolderMime},
+			{Id: "fbDoc", Name: "Notes", MimeType: drivePath.DocumentMime},
+			{Id: "fbImg", Name: "Sadhguru.jpg", MimeType: "image/jpeg"},
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := parentQuery.FindStringSubmatch(r.URL.Query().Get("q"))
+		if match == nil {
+			t.Fatalf("unexpected query %q", r.URL.Query().Get("q"))
+		}
+		json.NewEncoder(w).Encode(drive.FileList{Files: folders[match[1]]})
+	}))
+}
+
+// Keeps objects in memory and honours If-Match / If-None-Match of PutObject like S3
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string]string
+	version int
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	body, exists := b.objects[key]
+	etag := fmt.Sprintf(`"%d"`, b.version)
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, body)
+	case http.MethodPut:
+		ifMatch := r.Header.Get("If-Match")
+		if (r.Header.Get("If-None-Match") == "*" && exists) || (ifMatch != "" && ifMatch != etag) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		b.objects[key] = string(data)
+		b.version++
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, b.version))
+	default:
+		http.Error(w, "unexpected method "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSelectImageFallback(t *testing.T) {
+	type tc struct {
+		name     string
+		date     string
+		chain    []string
+		used     string
+		imageId  string
+		strategy string
+		usedList string
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "image for date", date: "2025-05-10", chain: []string{strategyDefault}, imageId: "img10", strategy: strategyDate},
+		{name: "no fallback", date: "2025-05-05", wantErr: true},
+		{name: "previous day", date: "2025-05-05", chain: []string{strategyPreviousDay, strategyRandomUnused, strategyDefault}, imageId: "img3", strategy: strategyPreviousDay},
+		{name: "no previous day in the month", date: "2025-05-02", chain: []string{strategyPreviousDay}, wantErr: true},
+		{
+			name:     "random unused creates used list",
+			date:     "2025-05-02",
+			chain:    []string{strategyPreviousDay, strategyRandomUnused, strategyDefault},
+			imageId:  "fbImg",
+			strategy: strategyRandomUnused,
+			usedList: `["fbImg"]`,
+		},
+		{
+			name:     "random unused appends to used list",
+			date:     "2025-06-01",
+			chain:    []string{strategyPreviousDay, strategyRandomUnused},
+			used:     `["other"]`,
+			imageId:  "fbImg",
+			strategy: strategyRandomUnused,
+			usedList: `["other","fbImg"]`,
+		},
+		{
+			name:     "all used falls back to default",
+			date:     "2025-05-02",
+			chain:    []string{strategyPreviousDay, strategyRandomUnused, strategyDefault},
+			used:     `["fbImg"]`,
+			imageId:  "default",
+			strategy: strategyDefault,
+			usedList: `["fbImg"]`,
+		},
+		{name: "missing month falls back to default", date: "2025-07-01", chain: []string{strategyPreviousDay, strategyDefault}, imageId: "default", strategy: strategyDefault},
+		{name: "all strategies fail", date: "2025-05-02", chain: []string{strategyPreviousDay, strategyRandomUnused}, used: `["fbImg"]`, usedList: `["fbImg"]`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			driveServer := archiveServer(t)
+			defer driveServer.Close()
+			svc, err := drive.NewService(ctx, option.WithEndpoint(driveServer.URL), option.WithHTTPClient(driveServer.Client()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resolver = drivePath.NewResolver(svc)
+
+			bucket := &fakeBucket{objects: map[string]string{}}
+			if test.used != "" {
+				bucket.objects[defaultUsedKey] = test.used
+			}
+			s3Server := httptest.NewServer(bucket)
+			defer s3Server.Close()
+			s3c = s3.New(s3.Options{
+				Region:       "us-east-1",
+				BaseEndpoint: aws.String(s3Server.URL),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+				HTTPClient:   s3Server.Client(),
+			})
+
+			event := Event{
+				DriveFolderId: "archive",
+				S3Bucket:      "bucket",
+				Fallback: Fallback{
+					Chain:         test.chain,
+					FolderId:      "fallback",
+					DefaultFileId: "default",
+				},
+			}
+			if err := event.Fallback.validate(); err != nil {
+				t.Fatal(err)
+			}
+			sel, err := newSelector(Matchers{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			date, err := time.Parse(plainDateLayout, test.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imageId, strategy, err := sel.selectImage(ctx, event, date)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("selectImage(%s) = %s (%s), want error", test.date, imageId, strategy)
+				}
+			} else if err != nil {
+				t.Fatalf("selectImage(%s) error: %v", test.date, err)
+			} else if imageId != test.imageId || strategy != test.strategy {
+				t.Errorf("selectImage(%s) = %s (%s), want %s (%s)", test.date, imageId, strategy, test.imageId, test.strategy)
+			}
+			if got := bucket.objects[defaultUsedKey]; got != test.usedList {
+				t.Errorf("used list = %s, want %s", got, test.usedList)
+			}
+		})
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -18,6 +17,7 @@ import (
 
 	"lambdalib/clientInit"
 	"lambdalib/configRead"
+	"lambdalib/drivePath"
 	"lambdalib/fileTransfer"
 )
 
@@ -25,19 +25,50 @@ var (
 	s3c      *s3.Client
 	log      *zap.SugaredLogger
 	driveSvc *drive.Service
+	resolver *drivePath.Resolver
 )
 
 type Event struct {
-	JobId         string    `json:"jobId"`
-	Direction     string    `json:"direction"`
-	DriveFolderId string    `json:"driveFolderId"`
-	DriveId       string    `json:"driveId"`
-	S3Bucket      string    `json:"s3Bucket"`
-	S3Key         string    `json:"s3Key"`
-	Date          time.Time `json:"date,omitempty"`
+	JobId         string `json:"jobId"`
+	Direction     string `json:"direction"`
+	DriveFolderId string `json:"driveFolderId"`
+	DriveId       string `json:"driveId"`
+	// Optional path like "Photo Archives", relative to driveFolderId or to the root of driveId
+	DriveFolderPath string `json:"driveFolderPath,omitempty"`
+	S3Bucket        string `json:"s3Bucket"`
+	S3Key           string `json:"s3Key"`
+	// YYYY-MM-DD or RFC 3339 timestamp, see parseDate
+	Date string `json:"date,omitempty"`
+	// IANA time zone, e.g. "Asia/Kolkata", used to get the day of a timestamp
+	TimeZone string `json:"timeZone,omitempty"`
+	// Patterns of year/month folders and day files, default is the convention in docs/dmq/api.md
+	Matchers Matchers `json:"matchers,omitempty"`
+	// What to use when there is no image for the date
+	Fallback Fallback `json:"fallback,omitempty"`
+	// Optional normalization of the photo, without it the original file is copied
+	Preprocess *Preprocess `json:"preprocess,omitempty"`
+	// Batch driveToS3: inclusive range of dates (same format as date), s3Key is used as prefix
+	DateFrom string `json:"dateFrom,omitempty"`
+	DateTo   string `json:"dateTo,omitempty"`
+	// Batch s3ToDrive: results to upload
+	Uploads []Upload `json:"uploads,omitempty"`
+	// s3ToDrive: what to do when the result name exists, see fileTransfer.ConflictPolicy. Default is replace.
+	Conflict string `json:"conflict,omitempty"`
+}
+
+type Response struct {
+	ImageId string `json:"imageId,omitempty"`
+	// How the image was selected: "date" or the fallback strategy
+	Strategy string `json:"strategy,omitempty"`
+	// Names skipped during the search because they don't match Matchers
+	Unparsed []string `json:"unparsed,omitempty"`
+	// Batch result, one item per date or upload
+	Items  []BatchItem `json:"items,omitempty"`
+	Failed int         `json:"failed,omitempty"`
 }
 
 func main() {
+	initClients()
 	lambda.Start(HandleRequest)
 }
 func init() {
@@ -46,7 +77,10 @@ func init() {
 	logger, _ := logConfig.Build()
 	defer logger.Sync()
 	log = logger.Sugar()
+}
 
+// Not in init, so tests of the package run without AWS and Google access
+func initClients() {
 	ctx := context.Background()
 
 	var err error
@@ -70,174 +104,203 @@ func init() {
 	if err != nil {
 		log.Fatal("Error initializig Google service client: ", err)
 	}
+	resolver = drivePath.NewResolver(driveSvc)
+}
+
+// The archive has no image for the date. Other errors, e.g. failed listing, are not a reason for a fallback.
+type notFoundError struct {
+	Err error
+}
+
+func (e *notFoundError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *notFoundError) Unwrap() error {
+	return e.Err
 }
 
-func selectFileByDay(ctx context.Context, driveId string, searchFolderId string, day int) (string, error) {
+func isNotFound(err error) bool {
+	var notFound *notFoundError
+	return errors.As(err, &notFound)
+}
+
+func (s *selector) selectFileByDay(ctx context.Context, driveId string, searchFolderId string, day int) (string, error) {
 	log.Debugf("Searching %s for day %d", searchFolderId, day)
-	folderIter, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false", searchFolderId)).
-		Fields("files(id, name)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	// Listing is cached, previous day fallback searches the same folder again
+	files, err := resolver.Children(ctx, driveId, searchFolderId, "")
 	if err != nil {
-		return "", errors.Join(fmt.Errorf("Error listing drive folder: %s", searchFolderId), err)
+		return "", err
 	}
 
-	if len(folderIter.Files) == 0 {
-		return "", errors.Join(fmt.Errorf("There is no file in: %s", searchFolderId), err)
+	if len(files) == 0 {
+		return "", &notFoundError{fmt.Errorf("There is no file in: %s", searchFolderId)}
 	}
 
-	errEncountered := false
-	for _, image := range folderIter.Files {
-		split := strings.SplitN(image.Name, "-", 3)
-		if len(split) != 3 {
-			errEncountered = true
-			log.Warnf("File name was not split into expected number of segments searchfolder=%s splitLen=%d expect=3 file=%s", searchFolderId, len(split), image.Name)
-			continue
-		}
-		imgNumStr := split[1]
-		imgNum, err := strconv.Atoi(imgNumStr)
-		if err != nil {
-			errEncountered = true
-			log.Warnf("First segment of folder name does not contain valid number searchfolder=%s file=%s", searchFolderId, image.Name)
-			continue
-		}
-
-		if imgNum == day {
+	for _, image := range files {
+		if s.matches(s.day, image.Name, day) {
 			return image.Id, nil
 		}
 	}
-
-	if errEncountered {
-		err = fmt.Errorf("During iteration some errors raised")
-	} else {
-		err = nil
-	}
-	return "", errors.Join(fmt.Errorf("Image for day %d not found in %s", day, searchFolderId), err)
+	return "", &notFoundError{errors.Join(fmt.Errorf("Image for day %d not found in %s", day, searchFolderId), s.unparsedErr())}
 }
-func selectFolderByMonth(ctx context.Context, driveId string, searchFolderId string, month int) (string, error) {
+func (s *selector) selectFolderByMonth(ctx context.Context, driveId string, searchFolderId string, month int) (string, error) {
 	log.Debugf("Searching %s for month %d", searchFolderId, month)
-	folderIter, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false and mimeType = 'application/vnd.google-apps.folder'", searchFolderId)).
-		Fields("files(id, name)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	folders, err := resolver.Children(ctx, driveId, searchFolderId, drivePath.FolderMime)
 	if err != nil {
-		return "", errors.Join(fmt.Errorf("Error listing drive folder: %s", searchFolderId), err)
+		return "", err
 	}
 
-	if len(folderIter.Files) == 0 {
-		return "", errors.Join(fmt.Errorf("There is no folder in: %s", searchFolderId), err)
+	if len(folders) == 0 {
+		return "", &notFoundError{fmt.Errorf("There is no folder in: %s", searchFolderId)}
 	}
 
-	errEncountered := false
-	for _, monthFolder := range folderIter.Files {
-		split := strings.SplitN(monthFolder.Name, " ", 2)
-		if len(split) != 2 {
-			errEncountered = true
-			log.Warnf("Folder name was not split into expected number of segments searchfolder=%s splitLen=%d expect=2 folder=%s", searchFolderId, len(split), monthFolder.Name)
-			continue
-		}
-		folderNumStr := split[0]
-		folderNum, err := strconv.Atoi(folderNumStr)
-		if err != nil {
-			errEncountered = true
-			log.Warnf("First segment of folder name does not contain valid number searchfolder=%s folder=%s", searchFolderId, monthFolder.Name)
-			continue
-		}
-
-		if folderNum == month {
+	for _, monthFolder := range folders {
+		if s.matches(s.month, monthFolder.Name, month) {
 			return monthFolder.Id, nil
 		}
 	}
-
-	if errEncountered {
-		err = fmt.Errorf("During iteration some errors raised")
-	} else {
-		err = nil
-	}
-	return "", errors.Join(fmt.Errorf("Folder for month %d not found in %s", month, searchFolderId), err)
+	return "", &notFoundError{errors.Join(fmt.Errorf("Folder for month %d not found in %s", month, searchFolderId), s.unparsedErr())}
 }
-func getImageByDate(ctx context.Context, driveId string, searchFolderId string, date time.Time) (string, error) {
+
+// Returns ID of the month folder (empty when not found) and ID of the image
+func (s *selector) getImageByDate(ctx context.Context, driveId string, searchFolderId string, date time.Time) (string, string, error) {
 	year := date.Year()
 	log.Debugf("Searching folder %s for year %d", searchFolderId, year)
-	folderIter, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false and mimeType = 'application/vnd.google-apps.folder'", searchFolderId)).
-		Fields("files(id, name)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	folders, err := resolver.Children(ctx, driveId, searchFolderId, drivePath.FolderMime)
 	if err != nil {
-		return "", errors.Join(fmt.Errorf("Error listing drive folder: %s", searchFolderId), err)
+		return "", "", err
 	}
 
-	if len(folderIter.Files) == 0 {
-		return "", errors.Join(fmt.Errorf("There is no folder in: ", searchFolderId), err)
+	if len(folders) == 0 {
+		return "", "", &notFoundError{fmt.Errorf("There is no folder in: %s", searchFolderId)}
 	}
 
-	for _, yearFolder := range folderIter.Files {
-		if strings.Contains(yearFolder.Name, strconv.Itoa(year)) {
-			monthId, err := selectFolderByMonth(ctx, driveId, yearFolder.Id, int(date.Month()))
+	for _, yearFolder := range folders {
+		if s.matches(s.year, yearFolder.Name, year) {
+			monthId, err := s.selectFolderByMonth(ctx, driveId, yearFolder.Id, int(date.Month()))
 			if err != nil {
-				return "", err
+				return "", "", err
 			}
 
-			img, err := selectFileByDay(ctx, driveId, monthId, date.Day())
+			img, err := s.selectFileByDay(ctx, driveId, monthId, date.Day())
 			if err != nil {
-				return "", err
+				return monthId, "", err
 			}
-			return img, nil
+			return monthId, img, nil
 		}
 	}
 
-	return "", fmt.Errorf("Folder for year %d not found in %s", year, searchFolderId)
+	return "", "", &notFoundError{errors.Join(fmt.Errorf("Folder for year %d not found in %s", year, searchFolderId), s.unparsedErr())}
 }
 
-func HandleRequest(ctx context.Context, event Event) error {
-	log.Infof("jobid=%s", event.JobId)
+// Validates selection options of the driveToS3 direction
+func prepareSelection(event Event) (*selector, error) {
+	sel, err := newSelector(event.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	err = event.Fallback.validate()
+	if err != nil {
+		return nil, err
+	}
+	if event.Preprocess != nil {
+		err = event.Preprocess.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
 
-	log.Infof("direction=%s", event.Direction)
-	switch event.Direction {
-	case "s3ToDrive":
-		dateStr := fmt.Sprintf("%d-%02d-%02d", event.Date.Year(), int(event.Date.Month()), event.Date.Day())
+// Selects image for the date and copies it to s3Key. Returns image ID and selection strategy.
+func copyImage(ctx context.Context, event Event, sel *selector, date time.Time, s3Key string) (string, string, error) {
+	log.Debugf("looking for image with date %s", date.Format(plainDateLayout))
+	imageId, strategy, err := sel.selectImage(ctx, event, date)
+	if err != nil {
+		return "", "", err
+	}
+	log.Infof("selected image=%s strategy=%s", imageId, strategy)
 
-		keySplit := strings.Split(event.S3Key, "/")
-		fileName := keySplit[len(keySplit) - 1]
-		finalName := strings.Replace(fileName, "result-", "", -1)
+	if event.Preprocess != nil {
+		log.Debug("preprocess from drive to s3")
+		err = preprocessToS3(ctx, imageId, event.S3Bucket, s3Key, *event.Preprocess)
+	} else {
+		log.Debug("copy from drive to s3")
+		err = fileTransfer.DriveToS3(ctx, s3c, driveSvc, imageId, event.S3Bucket, s3Key)
+	}
+	return imageId, strategy, err
+}
 
-		finalName = fmt.Sprintf("%s_%s", dateStr, finalName)
+// Uploads result image to Drive, named after the date. Returns ID of the Drive file.
+func uploadResult(ctx context.Context, event Event, s3Key string, date time.Time) (string, error) {
+	keySplit := strings.Split(s3Key, "/")
+	fileName := keySplit[len(keySplit)-1]
+	finalName := strings.Replace(fileName, "result-", "", -1)
 
-		log.Debugf("uploading from bucket=%s key=%s to driveFolder=%s file=%s", event.S3Bucket, event.S3Key, event.DriveFolderId, finalName)
-		err := fileTransfer.S3ToDrive(ctx, s3c, driveSvc, event.S3Bucket, event.S3Key, event.DriveFolderId, finalName, "image/png")
+	finalName = fmt.Sprintf("%s_%s", date.Format(plainDateLayout), finalName)
+
+	log.Debugf("uploading from bucket=%s key=%s to driveFolder=%s file=%s", event.S3Bucket, s3Key, event.DriveFolderId, finalName)
+	conflict := fileTransfer.ConflictReplace
+	if event.Conflict != "" {
+		conflict = fileTransfer.ConflictPolicy(event.Conflict)
+	}
+	result, err := fileTransfer.S3ToDriveWithOptions(ctx, s3c, driveSvc, event.S3Bucket, s3Key, event.DriveFolderId, finalName, "image/png", fileTransfer.S3ToDriveOptions{
+		Conflict: conflict,
+	})
+	if err != nil {
+		var mismatch *fileTransfer.ErrChecksumMismatch
+		if errors.As(err, &mismatch) {
+			return "", mismatch
+		}
+		return "", errors.Join(errors.New("Fail upload DMQ"), err)
+	}
+	return result.FileId, nil
+}
 
+func HandleRequest(ctx context.Context, event Event) (Response, error) {
+	log.Infof("jobid=%s", event.JobId)
+	response := Response{}
+
+	folderId, err := resolver.FolderId(ctx, event.DriveId, event.DriveFolderId, event.DriveFolderPath)
+	if err != nil {
+		return response, err
+	}
+	event.DriveFolderId = folderId
+
+	if event.isBatch() {
+		return handleBatch(ctx, event)
+	}
+
+	date, err := parseDate(event.Date, event.TimeZone)
+	if err != nil {
+		return response, err
+	}
+
+	log.Infof("direction=%s", event.Direction)
+	switch event.Direction {
+	case "s3ToDrive":
+		_, err = uploadResult(ctx, event, event.S3Key, date)
 		if err != nil {
-			return errors.Join(errors.New("Fail upload DMQ"), err)
+			return response, err
 		}
 
 	case "driveToS3":
-		log.Debugf("looking for image with date %s", event.Date.String())
-		imageId, err := getImageByDate(ctx, event.DriveId, event.DriveFolderId, event.Date)
+		sel, err := prepareSelection(event)
 		if err != nil {
-			return err
+			return response, err
+		}
+		imageId, strategy, err := copyImage(ctx, event, sel, date, event.S3Key)
+		response.Unparsed = sel.Unparsed
+		if len(sel.Unparsed) > 0 {
+			log.Warnf("names not matching the convention: %v", sel.Unparsed)
 		}
-
-		log.Debug("copy from drive to s3")
-		err = fileTransfer.DriveToS3(ctx, s3c, driveSvc, imageId, event.S3Bucket, event.S3Key)
 		if err != nil {
-			return err
+			return response, err
 		}
+		response.ImageId = imageId
+		response.Strategy = strategy
 	}
 
-	return nil
+	return response, nil
 }
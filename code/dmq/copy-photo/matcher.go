@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Folder name contains the year anywhere, e.g. "SQ Photos 2025"
+	defaultYearPattern = `(?P<year>\d{4})`
+	// Folder name starts with month number followed by space, e.g. "01 Jan 2025"
+	defaultMonthPattern = `^(?P<month>\d+) `
+	// Day is the second dash separated segment, e.g. "Jan-1-20200705_KSW_1277-e.jpg"
+	defaultDayPattern = `^[^-]*-(?P<day>\d+)-`
+)
+
+// Regular expressions matched against folder and file names of the photo archive.
+// Each must contain its named group: (?P<year>...), (?P<month>...) or (?P<day>...).
+// Empty pattern means the default convention described in docs/dmq/api.md.
+type Matchers struct {
+	Year  string `json:"year,omitempty"`
+	Month string `json:"month,omitempty"`
+	Day   string `json:"day,omitempty"`
+}
+
+type matcher struct {
+	re    *regexp.Regexp
+	group int
+	name  string
+}
+
+func newMatcher(pattern string, fallback string, group string) (*matcher, error) {
+	if pattern == "" {
+		pattern = fallback
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s matcher %q: %w", group, pattern, err)
+	}
+	index := re.SubexpIndex(group)
+	if index < 0 {
+		return nil, fmt.Errorf("Matcher %q must contain named group (?P<%s>...)", pattern, group)
+	}
+	return &matcher{re: re, group: index, name: group}, nil
+}
+
+// Month can be a number (1 = January) or an English name, full or abbreviated
+func parseNumber(group string, value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err == nil {
+		return number, nil
+	}
+	if group == "month" {
+		for month := time.January; month <= time.December; month++ {
+			name := month.String()
+			if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+				return int(month), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%s %q is not a number", group, value)
+}
+
+// Returns numbers captured by the named group in all matches, so "Photos 2024-2025" carries both years
+func (m *matcher) parse(name string) ([]int, error) {
+	matches := m.re.FindAllStringSubmatch(name, -1)
+	if matches == nil {
+		return nil, fmt.Errorf("does not match %s", m.re.String())
+	}
+	var numbers []int
+	for _, match := range matches {
+		number, err := parseNumber(m.name, match[m.group])
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// Compiled matchers with the report of names which could not be parsed
+type selector struct {
+	year  *matcher
+	month *matcher
+	day   *matcher
+
+	Unparsed []string
+}
+
+func newSelector(matchers Matchers) (*selector, error) {
+	year, err := newMatcher(matchers.Year, defaultYearPattern, "year")
+	if err != nil {
+		return nil, err
+	}
+	month, err := newMatcher(matchers.Month, defaultMonthPattern, "month")
+	if err != nil {
+		return nil, err
+	}
+	day, err := newMatcher(matchers.Day, defaultDayPattern, "day")
+	if err != nil {
+		return nil, err
+	}
+	return &selector{year: year, month: month, day: day}, nil
+}
+
+// Returns true when name carries the wanted number. Names which can't be parsed are recorded.
+func (s *selector) matches(m *matcher, name string, want int) bool {
+	numbers, err := m.parse(name)
+	if err != nil {
+		report := fmt.Sprintf("%s: %s", name, err)
+		if !slices.Contains(s.Unparsed, report) {
+			s.Unparsed = append(s.Unparsed, report)
+		}
+		return false
+	}
+	return slices.Contains(numbers, want)
+}
+
+func (s *selector) unparsedErr() error {
+	if len(s.Unparsed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Names not matching the convention: %s", strings.Join(s.Unparsed, "; "))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultMatchers(t *testing.T) {
+	type tc struct {
+		name     string
+		group    string
+		input    string
+		expected []int
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "year anywhere in folder name", group: "year", input: "SQ Photos 2025", expected: []int{2025}},
+		{name: "folder with two years", group: "year", input: "Photos 2024-2025", expected: []int{2024, 2025}},
+		{name: "folder without year", group: "year", input: "Photos", wantErr: true},
+		{name: "month number prefix", group: "month", input: "01 Jan 2025", expected: []int{1}},
+		{name: "month without space after number", group: "month", input: "01-Jan-2025", wantErr: true},
+		{name: "month name is not default convention", group: "month", input: "Jan 2025", wantErr: true},
+		{name: "day in second segment", group: "day", input: "Jan-1-20200705_KSW_1277-e.jpg", expected: []int{1}},
+		{name: "day with leading zero", group: "day", input: "Jan-05-20200705.jpg", expected: []int{5}},
+		{name: "file without dashes", group: "day", input: "IMG_1234.jpg", wantErr: true},
+	}
+
+	s, err := newSelector(Matchers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matchers := map[string]*matcher{"year": s.year, "month": s.month, "day": s.day}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := matchers[test.group].parse(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parse(%q) = %v, want error", test.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse(%q) error: %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("parse(%q) = %v, want %v", test.input, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestCustomMatchers(t *testing.T) {
+	type tc struct {
+		name     string
+		matchers Matchers
+		input    string
+		expected []int
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "month name", matchers: Matchers{Month: `^(?P<month>[A-Za-z]+) `}, input: "January 2025", expected: []int{1}},
+		{name: "abbreviated month name", matchers: Matchers{Month: `^(?P<month>[A-Za-z]+) `}, input: "sep 2025", expected: []int{9}},
+		{name: "unknown month name", matchers: Matchers{Month: `^(?P<month>[A-Za-z]+) `}, input: "Photos 2025", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := newSelector(test.matchers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.month.parse(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parse(%q) = %v, want error", test.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse(%q) error: %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("parse(%q) = %v, want %v", test.input, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestInvalidMatchers(t *testing.T) {
+	type tc struct {
+		name     string
+		matchers Matchers
+	}
+
+	tests := []tc{
+		{name: "invalid regex", matchers: Matchers{Year: `(\d{4}`}},
+		{name: "missing named group", matchers: Matchers{Day: `^(\d+)-`}},
+		{name: "group of other level", matchers: Matchers{Month: `^(?P<day>\d+) `}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newSelector(test.matchers); err == nil {
+				t.Errorf("newSelector(%+v) accepted invalid matcher", test.matchers)
+			}
+		})
+	}
+}
+
+func TestSelectorUnparsed(t *testing.T) {
+	s, err := newSelector(Matchers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"Jan-1-a.jpg", "IMG_1.jpg", "Jan-2-b.jpg", "IMG_1.jpg", "notes.txt"}
+	var matched []string
+	for _, name := range names {
+		if s.matches(s.day, name, 1) {
+			matched = append(matched, name)
+		}
+	}
+
+	if !reflect.DeepEqual(matched, []string{"Jan-1-a.jpg"}) {
+		t.Errorf("matched %v", matched)
+	}
+	if len(s.Unparsed) != 2 {
+		t.Errorf("unparsed names should be reported once, got %q", s.Unparsed)
+	}
+	if s.unparsedErr() == nil {
+		t.Error("unparsedErr() = nil with unparsed names")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"lambdalib/fileTransfer"
+)
+
+const (
+	defaultJpegQuality = 90
+	// Larger downloads are refused, decoding them would not fit Lambda memory
+	maxSourceSize = 100 * 1024 * 1024
+	// JPEG metadata comes before the image data, orientation is looked up in this many first bytes
+	exifHeadSize = 256 * 1024
+)
+
+// Fails the read once more than limit bytes come, instead of ending the stream early like io.LimitReader
+type sizeLimitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if rest := l.limit - l.read + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, fmt.Errorf("File is larger than %d bytes", l.limit)
+	}
+	return n, err
+}
+
+// Keeps only the first limit bytes written to it
+type headBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if rest := h.limit - len(h.buf); rest > 0 {
+		h.buf = append(h.buf, p[:min(rest, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// Normalizes the photo before the maker gets it. Re-encoding drops all metadata (EXIF, XMP, ICC).
+type Preprocess struct {
+	// Center crop to width:height, e.g. "1:1" or "4:5". Empty keeps the aspect ratio.
+	AspectRatio string `json:"aspectRatio,omitempty"`
+	// Longest side in pixels, smaller images are not enlarged. Zero keeps the size.
+	MaxDimension int `json:"maxDimension,omitempty"`
+	// "jpeg" (default) or "png"
+	Format string `json:"format,omitempty"`
+	// JPEG quality 1-100, zero means 90
+	Quality int `json:"quality,omitempty"`
+}
+
+func parseAspectRatio(ratio string) (float64, error) {
+	if ratio == "" {
+		return 0, nil
+	}
+	w, h, ok := strings.Cut(ratio, ":")
+	if !ok {
+		return 0, fmt.Errorf("Aspect ratio %q must be in format width:height", ratio)
+	}
+	width, errW := strconv.ParseFloat(w, 64)
+	height, errH := strconv.ParseFloat(h, 64)
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, fmt.Errorf("Aspect ratio %q must contain two positive numbers", ratio)
+	}
+	return width / height, nil
+}
+
+func (p Preprocess) validate() error {
+	_, err := parseAspectRatio(p.AspectRatio)
+	if err != nil {
+		return err
+	}
+	if p.MaxDimension < 0 {
+		return fmt.Errorf("Max dimension must be positive, got %d", p.MaxDimension)
+	}
+	if p.Format != "" && p.Format != "jpeg" && p.Format != "png" {
+		return fmt.Errorf("Unsupported output format: %s", p.Format)
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("JPEG quality must be between 1 and 100, got %d", p.Quality)
+	}
+	return nil
+}
+
+// Largest centered rectangle with the given aspect ratio
+func cropRect(bounds image.Rectangle, ratio float64) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+	if ratio <= 0 || w == 0 || h == 0 {
+		return bounds
+	}
+	if float64(w)/float64(h) > ratio {
+		cw := int(math.Round(float64(h) * ratio))
+		x0 := bounds.Min.X + (w-cw)/2
+		return image.Rect(x0, bounds.Min.Y, x0+cw, bounds.Max.Y)
+	}
+	ch := int(math.Round(float64(w) / ratio))
+	y0 := bounds.Min.Y + (h-ch)/2
+	return image.Rect(bounds.Min.X, y0, bounds.Max.X, y0+ch)
+}
+
+// Size with the longest side limited to maxDimension
+func fitSize(w int, h int, maxDimension int) (int, int) {
+	longest := max(w, h)
+	if maxDimension == 0 || longest <= maxDimension {
+		return w, h
+	}
+	scale := float64(maxDimension) / float64(longest)
+	return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+}
+
+// Decodes JPEG/PNG/WebP from the stream, crops, scales and turns the image upright, then encodes it again.
+// Only the decoded pixels are held in memory, not the source file. Returns the encoded image and its content type.
+func preprocessImage(r io.Reader, opts Preprocess) ([]byte, string, error) {
+	head := &headBuffer{limit: exifHeadSize}
+	src, format, err := image.Decode(io.TeeReader(r, head))
+	if err != nil {
+		return nil, "", errors.Join(errors.New("Error decoding image"), err)
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(head.buf)
+	}
+	ratio, err := parseAspectRatio(opts.AspectRatio)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Work on the stored (not rotated) pixels, so only the small result is rotated.
+	// Center crop commutes with rotation, only the ratio flips for 90 degree turns.
+	if orientation >= 5 && ratio > 0 {
+		ratio = 1 / ratio
+	}
+	crop := cropRect(src.Bounds(), ratio)
+	w, h := fitSize(crop.Dx(), crop.Dy(), opts.MaxDimension)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w == crop.Dx() && h == crop.Dy() {
+		draw.Draw(dst, dst.Bounds(), src, crop.Min, draw.Src)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	}
+	result := orient(dst, orientation)
+
+	buf := &bytes.Buffer{}
+	if opts.Format == "png" {
+		err = png.Encode(buf, result)
+		return buf.Bytes(), "image/png", err
+	}
+	quality := opts.Quality
+	if quality == 0 {
+		quality = defaultJpegQuality
+	}
+	err = jpeg.Encode(buf, result, &jpeg.Options{Quality: quality})
+	return buf.Bytes(), "image/jpeg", err
+}
+
+// Downloads image from Drive, normalizes it and uploads the result to S3
+func preprocessToS3(ctx context.Context, fileId string, s3Bucket string, s3Key string, opts Preprocess) error {
+	resp, err := driveSvc.Files.Get(fileId).Context(ctx).Download()
+	if err != nil {
+		return errors.Join(fmt.Errorf("Unable to download file: %s", fileId), err)
+	}
+	defer resp.Body.Close()
+
+	source := &sizeLimitReader{r: resp.Body, limit: maxSourceSize}
+	out, contentType, err := preprocessImage(source, opts)
+	if err != nil {
+		return errors.Join(fmt.Errorf("Error preprocessing file: %s", fileId), err)
+	}
+	log.Infof("preprocessed image=%s from %d to %d bytes", fileId, source.read, len(out))
+
+	err = fileTransfer.BytesToS3(ctx, s3c, out, s3Bucket, s3Key, contentType)
+	if err != nil {
+		return errors.Join(fmt.Errorf("Error uploading preprocessed file: %s", fileId), err)
+	}
+	return nil
+}
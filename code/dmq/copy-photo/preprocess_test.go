@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCropRect(t *testing.T) {
+	type tc struct {
+		name     string
+		bounds   image.Rectangle
+		ratio    float64
+		expected image.Rectangle
+	}
+
+	tests := []tc{
+		{name: "no ratio keeps image", bounds: image.Rect(0, 0, 400, 300), ratio: 0, expected: image.Rect(0, 0, 400, 300)},
+		{name: "landscape to square", bounds: image.Rect(0, 0, 400, 300), ratio: 1, expected: image.Rect(50, 0, 350, 300)},
+		{name: "portrait to square", bounds: image.Rect(0, 0, 300, 400), ratio: 1, expected: image.Rect(0, 50, 300, 350)},
+		{name: "landscape to 4:5", bounds: image.Rect(0, 0, 1000, 500), ratio: 0.8, expected: image.Rect(300, 0, 700, 500)},
+		{name: "same ratio", bounds: image.Rect(0, 0, 1600, 900), ratio: 16.0 / 9, expected: image.Rect(0, 0, 1600, 900)},
+		{name: "bounds with offset", bounds: image.Rect(10, 20, 410, 320), ratio: 1, expected: image.Rect(60, 20, 360, 320)},
+		{name: "empty image", bounds: image.Rect(0, 0, 0, 0), ratio: 1, expected: image.Rect(0, 0, 0, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := cropRect(test.bounds, test.ratio)
+			if got != test.expected {
+				t.Errorf("cropRect(%v, %v) = %v, want %v", test.bounds, test.ratio, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	type tc struct {
+		name         string
+		w, h         int
+		maxDimension int
+		expectedW    int
+		expectedH    int
+	}
+
+	tests := []tc{
+		{name: "no limit", w: 4000, h: 3000, maxDimension: 0, expectedW: 4000, expectedH: 3000},
+		{name: "smaller than limit", w: 800, h: 600, maxDimension: 1080, expectedW: 800, expectedH: 600},
+		{name: "landscape", w: 4000, h: 3000, maxDimension: 1000, expectedW: 1000, expectedH: 750},
+		{name: "portrait", w: 3000, h: 4000, maxDimension: 1000, expectedW: 750, expectedH: 1000},
+		{name: "rounding", w: 1000, h: 333, maxDimension: 500, expectedW: 500, expectedH: 167},
+		{name: "thin side stays visible", w: 10000, h: 1, maxDimension: 100, expectedW: 100, expectedH: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, h := fitSize(test.w, test.h, test.maxDimension)
+			if w != test.expectedW || h != test.expectedH {
+				t.Errorf("fitSize(%d, %d, %d) = %d, %d, want %d, %d", test.w, test.h, test.maxDimension, w, h, test.expectedW, test.expectedH)
+			}
+		})
+	}
+}
+
+// Minimal JPEG header with APP1 EXIF segment holding only the orientation tag
+func exifJpeg(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+	data = append(data, segment...)
+	return append(data, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func TestExifOrientation(t *testing.T) {
+	type tc struct {
+		name     string
+		data     []byte
+		expected int
+	}
+
+	tests := []tc{
+		{name: "little endian", data: exifJpeg(binary.LittleEndian, 6), expected: 6},
+		{name: "big endian", data: exifJpeg(binary.BigEndian, 8), expected: 8},
+		{name: "invalid value", data: exifJpeg(binary.LittleEndian, 9), expected: 1},
+		{name: "truncated", data: exifJpeg(binary.LittleEndian, 6)[:20], expected: 1},
+		{name: "jpeg without exif", data: []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, expected: 1},
+		{name: "png", data: []byte("\x89PNG\r\n\x1a\n"), expected: 1},
+		{name: "empty", data: nil, expected: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := exifOrientation(test.data)
+			if got != test.expected {
+				t.Errorf("exifOrientation() = %d, want %d", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	type tc struct {
+		name        string
+		orientation int
+		// Red channel of the result, row by row
+		expected  []uint8
+		expectedW int
+	}
+
+	// 1 2 3
+	// 4 5 6
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range 6 {
+		src.Pix[i*4] = uint8(i + 1)
+	}
+
+	tests := []tc{
+		{name: "as stored", orientation: 1, expected: []uint8{1, 2, 3, 4, 5, 6}, expectedW: 3},
+		{name: "mirrored", orientation: 2, expected: []uint8{3, 2, 1, 6, 5, 4}, expectedW: 3},
+		{name: "rotated 180", orientation: 3, expected: []uint8{6, 5, 4, 3, 2, 1}, expectedW: 3},
+		{name: "flipped", orientation: 4, expected: []uint8{4, 5, 6, 1, 2, 3}, expectedW: 3},
+		{name: "transposed", orientation: 5, expected: []uint8{1, 4, 2, 5, 3, 6}, expectedW: 2},
+		{name: "rotated 90 clockwise", orientation: 6, expected: []uint8{4, 1, 5, 2, 6, 3}, expectedW: 2},
+		{name: "transversed", orientation: 7, expected: []uint8{6, 3, 5, 2, 4, 1}, expectedW: 2},
+		{name: "rotated 90 counterclockwise", orientation: 8, expected: []uint8{3, 6, 2, 5, 1, 4}, expectedW: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dst := orient(src, test.orientation)
+			var got []uint8
+			for i := 0; i < len(dst.Pix); i += 4 {
+				got = append(got, dst.Pix[i])
+			}
+			if dst.Rect.Dx() != test.expectedW || !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("orient(%d) = %v width %d, want %v width %d", test.orientation, got, dst.Rect.Dx(), test.expected, test.expectedW)
+			}
+		})
+	}
+}
+
+func TestPreprocessImage(t *testing.T) {
+	type tc struct {
+		name        string
+		orientation uint16
+		opts        Preprocess
+		expectedW   int
+		expectedH   int
+		contentType string
+	}
+
+	tests := []tc{
+		{name: "as stored", orientation: 1, expectedW: 40, expectedH: 20, contentType: "image/jpeg"},
+		{name: "rotated by exif", orientation: 6, expectedW: 20, expectedH: 40, contentType: "image/jpeg"},
+		{name: "crop and scale", orientation: 1, opts: Preprocess{AspectRatio: "1:1", MaxDimension: 10, Format: "png"}, expectedW: 10, expectedH: 10, contentType: "image/png"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := &bytes.Buffer{}
+			if err := jpeg.Encode(encoded, image.NewNRGBA(image.Rect(0, 0, 40, 20)), nil); err != nil {
+				t.Fatal(err)
+			}
+			// EXIF segment goes after SOI of the encoded image
+			header := exifJpeg(binary.LittleEndian, test.orientation)
+			data := append(header[:len(header)-4], encoded.Bytes()[2:]...)
+
+			out, contentType, err := preprocessImage(bytes.NewReader(data), test.opts)
+			if err != nil {
+				t.Fatalf("preprocessImage() error: %v", err)
+			}
+			result, _, err := image.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("decoding result: %v", err)
+			}
+			if got := result.Bounds(); got.Dx() != test.expectedW || got.Dy() != test.expectedH || contentType != test.contentType {
+				t.Errorf("preprocessImage() = %dx%d %s, want %dx%d %s", got.Dx(), got.Dy(), contentType, test.expectedW, test.expectedH, test.contentType)
+			}
+		})
+	}
+}
+
+func TestSizeLimitReader(t *testing.T) {
+	type tc struct {
+		name    string
+		size    int
+		wantErr bool
+	}
+
+	tests := []tc{
+		{name: "smaller", size: 9},
+		{name: "exactly the limit", size: 10},
+		{name: "one byte over", size: 11, wantErr: true},
+		{name: "empty", size: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := &sizeLimitReader{r: strings.NewReader(strings.Repeat("x", test.size)), limit: 10}
+			data, err := io.ReadAll(reader)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ReadAll() error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && (len(data) != test.size || reader.read != int64(test.size)) {
+				t.Errorf("read %d bytes (counted %d), want %d", len(data), reader.read, test.size)
+			}
+		})
+	}
+}
@@ -1,14 +1,335 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+
+	"lambdalib/clientInit"
+	"lambdalib/fileTransfer"
 )
 
+const (
+	defaultTarget = "webhook"
+
+	statePending   = "pending"
+	statePublished = "published"
+)
+
+var (
+	s3c        *s3.Client
+	log        *zap.SugaredLogger
+	publishers = map[string]Publisher{}
+	fallback   Publisher
+	// Targets of the state machine, other targets are refused instead of going to the webhook
+	allowedTargets map[string]bool
+)
+
+type Event struct {
+	JobId    string `json:"jobId"`
+	Target   string `json:"target"`
+	Text     string `json:"text"`
+	Date     string `json:"date"`
+	S3Bucket string `json:"s3Bucket"`
+	S3Key    string `json:"s3Key"`
+	DryRun   bool   `json:"dryRun,omitempty"`
+}
+
+type Response struct {
+	PostId           string `json:"postId"`
+	Target           string `json:"target"`
+	DryRun           bool   `json:"dryRun"`
+	AlreadyPublished bool   `json:"alreadyPublished"`
+}
+
+type publishRecord struct {
+	// pending is written before the post, published after it
+	State       string    `json:"state"`
+	JobId       string    `json:"jobId"`
+	PostId      string    `json:"postId,omitempty"`
+	Target      string    `json:"target"`
+	PublishedAt time.Time `json:"publishedAt,omitempty"`
+}
+
 func main() {
 	lambda.Start(HandleRequest)
 }
+func init() {
+	logConfig := zap.NewProductionConfig()
+	logConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	logger, _ := logConfig.Build()
+	defer logger.Sync()
+	log = logger.Sugar()
+
+	ctx := context.Background()
+
+	var err error
+	var cfg *aws.Config
+
+	s3c, cfg, err = clientInit.InitS3(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	allowedTargets = parseTargets(os.Getenv("PUBLISH_TARGETS"))
+
+	webhookUrl := os.Getenv("WEBHOOK_URL")
+	if webhookUrl != "" {
+		token := ""
+		if tokenParam := os.Getenv("SSM_WEBHOOK_TOKEN"); tokenParam != "" {
+			ssmc, _, err := clientInit.InitSSM(ctx, cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			param, err := ssmc.GetParameter(ctx, &ssm.GetParameterInput{
+				Name:           &tokenParam,
+				WithDecryption: aws.Bool(true),
+			})
+			if err != nil {
+				log.Fatal("Error reading webhook token: ", err)
+			}
+			token = *param.Parameter.Value
+		}
+		fallback = &WebhookPublisher{Url: webhookUrl, Token: token}
+		publishers[defaultTarget] = fallback
+	}
+}
+
+func isDryRun(event Event) bool {
+	if event.DryRun {
+		return true
+	}
+	dryRun, _ := strconv.ParseBool(os.Getenv("DRY_RUN"))
+	return dryRun
+}
+
+// Comma separated list of targets, the default target is always allowed
+func parseTargets(value string) map[string]bool {
+	targets := map[string]bool{defaultTarget: true}
+	for _, target := range strings.Split(value, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets[target] = true
+		}
+	}
+	return targets
+}
+
+func getPublisher(target string) (Publisher, error) {
+	if !allowedTargets[target] {
+		return nil, fmt.Errorf("Unknown target=%s, allowed are PUBLISH_TARGETS", target)
+	}
+	if publisher, ok := publishers[target]; ok {
+		return publisher, nil
+	}
+	if fallback != nil {
+		log.Debugf("No dedicated publisher for target=%s, using webhook", target)
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("No publisher configured for target=%s", target)
+}
+
+// recordKey is the marker object of the publish. It is keyed by job and target so Step Functions retries never post twice.
+func recordKey(event Event) string {
+	return path.Join("dmq/published", event.JobId, event.Target+".json")
+}
+
+func readRecord(ctx context.Context, bucket string, key string) (*publishRecord, error) {
+	buf := &bytes.Buffer{}
+	err := fileTransfer.S3ToLocal(ctx, s3c, bucket, key, buf)
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
 
-func HandleRequest(ctx context.Context, event string) error {
+	var record publishRecord
+	err = json.Unmarshal(buf.Bytes(), &record)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("Error decoding publish record s3=%s key=%s", bucket, key), err)
+	}
+	return &record, nil
+}
+
+// With onlyNew the record is written only if there is none yet, false is returned when it exists
+func writeRecord(ctx context.Context, bucket string, key string, record publishRecord, onlyNew bool) (bool, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	if onlyNew {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err = s3c.PutObject(ctx, input)
+	var apiErr smithy.APIError
+	if onlyNew && errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Join(fmt.Errorf("Error writing publish record s3=%s key=%s", bucket, key), err)
+	}
+	return true, nil
+}
+
+func deleteRecord(ctx context.Context, bucket string, key string) error {
+	_, err := s3c.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("Error deleting publish record s3=%s key=%s", bucket, key), err)
+	}
 	return nil
 }
+
+// Returns the image with the content type stored on the object, detected from the data when it's missing
+func readImage(ctx context.Context, bucket string, key string) ([]byte, string, error) {
+	obj, err := s3c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, "", errors.Join(fmt.Errorf("Error downloading file from s3=%s key=%s", bucket, key), err)
+	}
+	defer obj.Body.Close()
+
+	image, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, "", errors.Join(fmt.Errorf("Error reading file from s3=%s key=%s", bucket, key), err)
+	}
+	return image, imageContentType(aws.ToString(obj.ContentType), image), nil
+}
+
+// S3 defaults to a generic type when the uploader didn't set one
+func imageContentType(stored string, image []byte) string {
+	if stored == "" || stored == "binary/octet-stream" || stored == "application/octet-stream" {
+		return http.DetectContentType(image)
+	}
+	return stored
+}
+
+// A pending record means an attempt may have posted without recording it. Posting again could duplicate the post.
+func pendingErr(bucket string, key string) error {
+	return fmt.Errorf("Previous publish attempt did not finish, check the target and delete the record s3=%s key=%s to retry", bucket, key)
+}
+
+func HandleRequest(ctx context.Context, event Event) (Response, error) {
+	log.Infof("jobid=%s", event.JobId)
+	if event.JobId == "" {
+		return Response{}, errors.New("jobId is empty")
+	}
+	if event.Target == "" {
+		event.Target = defaultTarget
+	}
+	dryRun := isDryRun(event)
+	log.Infof("target=%s dryRun=%t", event.Target, dryRun)
+
+	markerKey := recordKey(event)
+	record, err := readRecord(ctx, event.S3Bucket, markerKey)
+	if err != nil {
+		return Response{}, err
+	}
+	if record != nil && record.State == statePending {
+		return Response{}, pendingErr(event.S3Bucket, markerKey)
+	}
+	if record != nil {
+		log.Infof("Already published postId=%s at %s", record.PostId, record.PublishedAt)
+		return Response{
+			PostId:           record.PostId,
+			Target:           event.Target,
+			AlreadyPublished: true,
+		}, nil
+	}
+
+	publisher, err := getPublisher(event.Target)
+	if err != nil {
+		return Response{}, err
+	}
+
+	image, contentType, err := readImage(ctx, event.S3Bucket, event.S3Key)
+	if err != nil {
+		return Response{}, err
+	}
+	log.Debugf("Loaded image s3=%s key=%s size=%d type=%s", event.S3Bucket, event.S3Key, len(image), contentType)
+
+	if dryRun {
+		log.Info("Dry run, skipping publish")
+		return Response{
+			Target: event.Target,
+			DryRun: true,
+		}, nil
+	}
+
+	created, err := writeRecord(ctx, event.S3Bucket, markerKey, publishRecord{
+		State:  statePending,
+		JobId:  event.JobId,
+		Target: event.Target,
+	}, true)
+	if err != nil {
+		return Response{}, err
+	}
+	if !created {
+		return Response{}, pendingErr(event.S3Bucket, markerKey)
+	}
+
+	postId, err := publisher.Publish(ctx, Post{
+		JobId:       event.JobId,
+		Target:      event.Target,
+		Text:        event.Text,
+		Date:        event.Date,
+		FileName:    path.Base(event.S3Key),
+		ContentType: contentType,
+		Image:       image,
+	})
+	if err != nil {
+		err = errors.Join(fmt.Errorf("Fail publish DMQ target=%s", event.Target), err)
+		if !isRejected(err) {
+			// The post may exist, e.g. the response was lost. The pending record stops retries until checked by hand.
+			return Response{}, errors.Join(err, pendingErr(event.S3Bucket, markerKey))
+		}
+		// The post was not created, so the retry may post
+		return Response{}, errors.Join(err, deleteRecord(ctx, event.S3Bucket, markerKey))
+	}
+	log.Infof("Published postId=%s", postId)
+
+	_, err = writeRecord(ctx, event.S3Bucket, markerKey, publishRecord{
+		State:       statePublished,
+		JobId:       event.JobId,
+		PostId:      postId,
+		Target:      event.Target,
+		PublishedAt: time.Now().UTC(),
+	}, false)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		PostId: postId,
+		Target: event.Target,
+	}, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+)
+
+type Post struct {
+	JobId       string
+	Target      string
+	Text        string
+	Date        string
+	FileName    string
+	ContentType string
+	Image       []byte
+}
+
+// Publisher posts a finished DMQ to one platform and returns the ID of the remote post.
+// It returns *RejectedError only when the post was certainly not created, then the publish can be retried.
+type Publisher interface {
+	Publish(ctx context.Context, post Post) (string, error)
+}
+
+// WebhookPublisher sends the post as multipart/form-data to a generic HTTP endpoint.
+// The endpoint is expected to respond with JSON containing "id" (or "postId").
+type WebhookPublisher struct {
+	Url    string
+	Token  string
+	Client *http.Client
+}
+
+// The target certainly did not create the post, e.g. it responded with 4xx status.
+// Other errors, like a timeout, leave it unknown whether the post exists.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+func isRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+type webhookResponse struct {
+	Id     string `json:"id"`
+	PostId string `json:"postId"`
+}
+
+func (w *WebhookPublisher) Publish(ctx context.Context, post Post) (string, error) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+
+	fields := map[string]string{
+		"jobId":  post.JobId,
+		"target": post.Target,
+		"text":   post.Text,
+		"date":   post.Date,
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return "", &RejectedError{errors.Join(fmt.Errorf("Error writing form field %s", name), err)}
+		}
+	}
+
+	partHeader := make(textproto.MIMEHeader)
+	// Quotes the name and escapes characters like " or non-ASCII ones
+	partHeader.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     "image",
+		"filename": post.FileName,
+	}))
+	partHeader.Set("Content-Type", post.ContentType)
+	part, err := form.CreatePart(partHeader)
+	if err != nil {
+		return "", &RejectedError{errors.Join(errors.New("Error creating image form part"), err)}
+	}
+	if _, err = part.Write(post.Image); err != nil {
+		return "", &RejectedError{errors.Join(errors.New("Error writing image form part"), err)}
+	}
+	if err = form.Close(); err != nil {
+		return "", &RejectedError{errors.Join(errors.New("Error closing multipart form"), err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Url, body)
+	if err != nil {
+		return "", &RejectedError{errors.Join(errors.New("Error creating webhook request"), err)}
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Idempotency-Key", fmt.Sprintf("%s-%s", post.JobId, post.Target))
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("Error calling webhook target=%s", post.Target), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", errors.Join(errors.New("Error reading webhook response"), err)
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
+		return "", &RejectedError{fmt.Errorf("Webhook responded with status %d: %s", resp.StatusCode, string(respBody))}
+	}
+	// 5xx may come after the post was created, like a timeout
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("Webhook responded with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var parsed webhookResponse
+	if err = json.Unmarshal(respBody, &parsed); err != nil {
+		return "", errors.Join(errors.New("Error decoding webhook response"), err)
+	}
+	if parsed.Id != "" {
+		return parsed.Id, nil
+	}
+	if parsed.PostId != "" {
+		return parsed.PostId, nil
+	}
+	return "", errors.New("Webhook response does not contain post id")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookPublish(t *testing.T) {
+	type tc struct {
+		name     string
+		status   int
+		response string
+		expected string
+		wantErr  bool
+		rejected bool
+	}
+
+	tests := []tc{
+		{
+			name:     "id field",
+			status:   200,
+			response: `{"id": "post-1"}`,
+			expected: "post-1",
+		},
+		{
+			name:     "postId field",
+			status:   201,
+			response: `{"postId": "post-2"}`,
+			expected: "post-2",
+		},
+		{
+			name:     "missing id",
+			status:   200,
+			response: `{}`,
+			wantErr:  true,
+		},
+		{
+			name:     "server error may be posted",
+			status:   500,
+			response: `oops`,
+			wantErr:  true,
+		},
+		{
+			name:     "bad gateway may be posted",
+			status:   502,
+			response: `oops`,
+			wantErr:  true,
+		},
+		{
+			name:     "bad request",
+			status:   400,
+			response: `{"error": "text too long"}`,
+			wantErr:  true,
+			rejected: true,
+		},
+		{
+			name:     "undecodable response may be posted",
+			status:   200,
+			response: `<html>`,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Idempotency-Key"); got != "abc-youtube" {
+					t.Errorf("Idempotency-Key = %q, want %q", got, "abc-youtube")
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+					t.Errorf("Authorization = %q", got)
+				}
+				if got := r.FormValue("text"); got != "quote" {
+					t.Errorf("text = %q, want %q", got, "quote")
+				}
+				file, header, err := r.FormFile("image")
+				if err != nil {
+					t.Fatalf("missing image: %v", err)
+				}
+				if header.Filename != `result "square".png` {
+					t.Errorf("filename = %q", header.Filename)
+				}
+				if got := header.Header.Get("Content-Type"); got != "image/png" {
+					t.Errorf("image Content-Type = %q, want %q", got, "image/png")
+				}
+				image, _ := io.ReadAll(file)
+				if string(image) != "png" {
+					t.Errorf("image = %q, want %q", image, "png")
+				}
+
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+
+			publisher := &WebhookPublisher{Url: server.URL, Token: "secret"}
+			got, err := publisher.Publish(context.Background(), Post{
+				JobId:       "abc",
+				Target:      "youtube",
+				Text:        "quote",
+				Date:        "2025-05-28",
+				FileName:    `result "square".png`,
+				ContentType: "image/png",
+				Image:       []byte("png"),
+			})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Publish() error = %v, wantErr %t", err, test.wantErr)
+			}
+			if isRejected(err) != test.rejected {
+				t.Errorf("Publish() rejected = %t, want %t", isRejected(err), test.rejected)
+			}
+			if got != test.expected {
+				t.Errorf("Publish() = %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestWebhookUnreachableIsNotRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	publisher := &WebhookPublisher{Url: url}
+	_, err := publisher.Publish(context.Background(), Post{JobId: "abc", Target: "youtube", Image: []byte("png")})
+	if err == nil {
+		t.Fatal("Publish() to closed server returned no error")
+	}
+	if isRejected(err) {
+		t.Errorf("Publish() error %v is rejected, the post may exist", err)
+	}
+}
+
+func TestImageContentType(t *testing.T) {
+	type tc struct {
+		name     string
+		stored   string
+		image    []byte
+		expected string
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	tests := []tc{
+		{name: "stored type", stored: "image/jpeg", image: png, expected: "image/jpeg"},
+		{name: "missing type is detected", stored: "", image: png, expected: "image/png"},
+		{name: "s3 default is detected", stored: "binary/octet-stream", image: png, expected: "image/png"},
+		{name: "octet stream is detected", stored: "application/octet-stream", image: png, expected: "image/png"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := imageContentType(test.stored, test.image)
+			if got != test.expected {
+				t.Errorf("imageContentType(%q) = %q, want %q", test.stored, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetPublisher(t *testing.T) {
+	type tc struct {
+		name     string
+		targets  string
+		target   string
+		fallback bool
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "allowed target uses webhook", targets: "youtube,facebook", target: "youtube", fallback: true},
+		{name: "default target", targets: "", target: defaultTarget, fallback: true},
+		{name: "spaces in list", targets: " youtube , facebook ", target: "facebook", fallback: true},
+		{name: "unknown target", targets: "youtube,facebook", target: "tiktok", fallback: true, wantErr: true},
+		{name: "empty target", targets: "youtube", target: "", fallback: true, wantErr: true},
+		{name: "no webhook", targets: "youtube", target: "youtube", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowedTargets = parseTargets(test.targets)
+			fallback = nil
+			if test.fallback {
+				fallback = &WebhookPublisher{Url: "http://localhost"}
+			}
+			defer func() { fallback = nil }()
+
+			publisher, err := getPublisher(test.target)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getPublisher(%q) error = %v, wantErr %t", test.target, err, test.wantErr)
+			}
+			if !test.wantErr && publisher != fallback {
+				t.Errorf("getPublisher(%q) = %v, want webhook", test.target, publisher)
+			}
+		})
+	}
+}
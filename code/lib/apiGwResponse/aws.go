@@ -0,0 +1,20 @@
+package apiGwResponse
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// Classifies error of an AWS call. Throttled and failing services are temporary, the caller may retry.
+func AwsErr(detail string, err error) *ErrApi {
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err).Bool() {
+		return UpstreamUnavailableErr(detail, err)
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500 {
+		return UpstreamUnavailableErr(detail, err)
+	}
+	return InternalErr(detail, err)
+}
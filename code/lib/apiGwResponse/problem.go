@@ -0,0 +1,158 @@
+package apiGwResponse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+type Kind string
+
+const (
+	KindValidation          Kind = "validation"
+	KindForbidden           Kind = "forbidden"
+	KindNotFound            Kind = "not-found"
+	KindConflict            Kind = "conflict"
+	KindQuotaExceeded       Kind = "quota-exceeded"
+	KindUpstreamUnavailable Kind = "upstream-unavailable"
+	KindInternal            Kind = "internal"
+
+	problemContentType = "application/problem+json"
+)
+
+var kindStatus = map[Kind]int{
+	KindValidation:          http.StatusBadRequest,
+	KindForbidden:           http.StatusForbidden,
+	KindNotFound:            http.StatusNotFound,
+	KindConflict:            http.StatusConflict,
+	KindQuotaExceeded:       http.StatusTooManyRequests,
+	KindUpstreamUnavailable: http.StatusServiceUnavailable,
+	KindInternal:            http.StatusInternalServerError,
+}
+
+// Error returned to the API caller. Detail is shown to the caller, Err only to the logs.
+type ErrApi struct {
+	Kind   Kind
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+func (e *ErrApi) Error() string {
+	if e.Err != nil {
+		return string(e.Kind) + ": " + e.Detail + ": " + e.Err.Error()
+	}
+	return string(e.Kind) + ": " + e.Detail
+}
+
+func (e *ErrApi) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrApi) Status() int {
+	status, ok := kindStatus[e.Kind]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+func ValidationErr(detail string, fields ...FieldError) *ErrApi {
+	return &ErrApi{Kind: KindValidation, Detail: detail, Fields: fields}
+}
+
+func ForbiddenErr(detail string) *ErrApi {
+	return &ErrApi{Kind: KindForbidden, Detail: detail}
+}
+
+func NotFoundErr(detail string) *ErrApi {
+	return &ErrApi{Kind: KindNotFound, Detail: detail}
+}
+
+func ConflictErr(detail string) *ErrApi {
+	return &ErrApi{Kind: KindConflict, Detail: detail}
+}
+
+func QuotaExceededErr(detail string) *ErrApi {
+	return &ErrApi{Kind: KindQuotaExceeded, Detail: detail}
+}
+
+func UpstreamUnavailableErr(detail string, err error) *ErrApi {
+	return &ErrApi{Kind: KindUpstreamUnavailable, Detail: detail, Err: err}
+}
+
+func InternalErr(detail string, err error) *ErrApi {
+	return &ErrApi{Kind: KindInternal, Detail: detail, Err: err}
+}
+
+// RFC 7807 problem details
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      Kind         `json:"code"`
+	RequestId string       `json:"requestId"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// Converts the error to problem+json response. Errors other than ErrApi are internal and their text is not exposed.
+func ProblemResponse(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	lctx, ok := lambdacontext.FromContext(ctx)
+	if !ok {
+		return events.APIGatewayProxyResponse{}, errors.Join(errors.New("Unable to read Lambda Context"), err)
+	}
+
+	var apiErr *ErrApi
+	if !errors.As(err, &apiErr) {
+		apiErr = InternalErr("Internal error, please report it with the requestId", err)
+	}
+	status := apiErr.Status()
+	responseBody, err := json.Marshal(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    apiErr.Detail,
+		Code:      apiErr.Kind,
+		RequestId: lctx.AwsRequestID,
+		Fields:    apiErr.Fields,
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Body:       string(responseBody),
+		Headers: map[string]string{
+			"Content-Type": problemContentType,
+		},
+	}, nil
+}
+
+type Handler[T any] func(ctx context.Context, event T) (events.APIGatewayProxyResponse, error)
+
+// Satisfied by zap.SugaredLogger
+type Logger interface {
+	Error(args ...any)
+}
+
+// Wraps the handler so returned errors become problem responses instead of opaque gateway errors.
+// Version and CORS headers of the route are added when the event implements Routed.
+func Wrap[T any](log Logger, handler Handler[T]) Handler[T] {
+	return func(ctx context.Context, event T) (events.APIGatewayProxyResponse, error) {
+		response, err := handler(ctx, event)
+		if err != nil {
+			log.Error("Request failed: ", err)
+			response, err = ProblemResponse(ctx, err)
+			if err != nil {
+				return response, err
+			}
+		}
+		addRouteHeaders(&response, event)
+		return response, nil
+	}
+}
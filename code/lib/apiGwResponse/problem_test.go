@@ -0,0 +1,75 @@
+package apiGwResponse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+type testLogger struct {
+	logged int
+}
+
+func (l *testLogger) Error(args ...any) {
+	l.logged++
+}
+
+func TestWrap(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+
+	tests := []struct {
+		err    error
+		status int
+		code   Kind
+		detail string
+	}{
+		{ValidationErr("Invalid request body", FieldError{Field: "/date", Message: "missing"}), 400, KindValidation, "Invalid request body"},
+		{ForbiddenErr("Key may not start this job"), 403, KindForbidden, "Key may not start this job"},
+		{fmt.Errorf("lookup: %w", NotFoundErr("Job x not found")), 404, KindNotFound, "Job x not found"},
+		{ConflictErr("Job exists"), 409, KindConflict, "Job exists"},
+		{QuotaExceededErr("Daily job quota is used up"), 429, KindQuotaExceeded, "Daily job quota is used up"},
+		{UpstreamUnavailableErr("Throttled", errors.New("ThrottlingException")), 503, KindUpstreamUnavailable, "Throttled"},
+		{errors.New("secret internals"), 500, KindInternal, "Internal error, please report it with the requestId"},
+	}
+
+	for _, tt := range tests {
+		log := &testLogger{}
+		handler := Wrap(log, func(ctx context.Context, event string) (events.APIGatewayProxyResponse, error) {
+			return events.APIGatewayProxyResponse{}, tt.err
+		})
+		response, err := handler(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if log.logged != 1 {
+			t.Errorf("error should be logged once, got %d", log.logged)
+		}
+		if response.StatusCode != tt.status || response.Headers["Content-Type"] != "application/problem+json" {
+			t.Errorf("got status %d content type %s", response.StatusCode, response.Headers["Content-Type"])
+		}
+
+		var problem Problem
+		err = json.Unmarshal([]byte(response.Body), &problem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problem.Status != tt.status || problem.Code != tt.code || problem.Detail != tt.detail || problem.RequestId != "req-1" {
+			t.Errorf("unexpected problem %+v", problem)
+		}
+	}
+}
+
+func TestWrapPassesResponse(t *testing.T) {
+	handler := Wrap(&testLogger{}, func(ctx context.Context, event string) (events.APIGatewayProxyResponse, error) {
+		return OkResponse(map[string]string{"jobId": event})
+	})
+	response, err := handler(context.Background(), "abc")
+	if err != nil || response.StatusCode != 200 || response.Body != `{"jobId":"abc"}` {
+		t.Errorf("unexpected response %+v %v", response, err)
+	}
+}
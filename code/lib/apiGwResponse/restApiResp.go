@@ -3,31 +3,26 @@ package apiGwResponse
 import (
 	"context"
 	"encoding/json"
-	"errors"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
 func ErrResponse(response string, ctx context.Context) (events.APIGatewayProxyResponse, error) {
-	lctx, ok := lambdacontext.FromContext(ctx)
-	if !ok {
-		return events.APIGatewayProxyResponse{}, errors.New("Unable to read Lambda Context")
-	}
+	return ProblemResponse(ctx, ValidationErr(response))
+}
 
-	execId := lctx.AwsRequestID
-	responseBody, _ := json.Marshal(map[string]string{
-		"error":     response,
-		"execution": execId,
-	})
-	return events.APIGatewayProxyResponse{
-		StatusCode: 400,
-		Body:       string(responseBody),
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
-	}, nil
+// Problem with one field of the request body
+type FieldError struct {
+	// JSON pointer to the field, empty for the whole body
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
+
+// 400 response listing each invalid field
+func ErrFieldsResponse(response string, fields []FieldError, ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	return ProblemResponse(ctx, ValidationErr(response, fields...))
+}
+
 func OkResponse(response any) (events.APIGatewayProxyResponse, error) {
 	responseBody, err := json.Marshal(response)
 	if err != nil {
@@ -0,0 +1,109 @@
+package apiGwResponse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Lifecycle of a route, announced to clients by response headers
+type RouteConfig struct {
+	// Sent as API-Version, e.g. "unstable-v2"
+	Version string `json:"version"`
+	// Since when the route is deprecated (RFC 9745)
+	Deprecation *time.Time `json:"deprecation,omitempty"`
+	// When the route will be removed (RFC 8594)
+	Sunset *time.Time `json:"sunset,omitempty"`
+	// Documentation of the deprecation and migration
+	Link string `json:"link,omitempty"`
+}
+
+// Request knowing its route (API Gateway resource path)
+type Routed interface {
+	RouteKey() string
+}
+
+// Headers clients may read from a browser
+var exposedHeaders = []string{"API-Version", "Deprecation", "Sunset", "Link"}
+
+var (
+	routes      = map[string]RouteConfig{}
+	allowOrigin string
+)
+
+// Reads route configuration from environment variables:
+//
+//   - ROUTE_VERSIONS JSON object mapping route to RouteConfig
+//   - CORS_ALLOW_ORIGIN value of Access-Control-Allow-Origin, no CORS headers when empty
+func ConfigureFromEnv() error {
+	config := map[string]RouteConfig{}
+	raw := os.Getenv("ROUTE_VERSIONS")
+	if raw != "" {
+		err := json.Unmarshal([]byte(raw), &config)
+		if err != nil {
+			return errors.Join(errors.New("ROUTE_VERSIONS expected JSON object of route configs"), err)
+		}
+	}
+	return ConfigureRoutes(config, os.Getenv("CORS_ALLOW_ORIGIN"))
+}
+
+func ConfigureRoutes(config map[string]RouteConfig, origin string) error {
+	for route, rc := range config {
+		if rc.Version == "" {
+			return fmt.Errorf("Route %s has no version", route)
+		}
+		if rc.Sunset != nil && rc.Deprecation != nil && rc.Sunset.Before(*rc.Deprecation) {
+			return fmt.Errorf("Route %s has sunset before deprecation", route)
+		}
+	}
+	routes = config
+	allowOrigin = origin
+	return nil
+}
+
+func RouteHeaders(route string) map[string]string {
+	headers := map[string]string{}
+	if allowOrigin != "" {
+		headers["Access-Control-Allow-Origin"] = allowOrigin
+		headers["Access-Control-Expose-Headers"] = strings.Join(exposedHeaders, ", ")
+	}
+
+	rc, ok := routes[route]
+	if !ok {
+		return headers
+	}
+	headers["API-Version"] = rc.Version
+	if rc.Deprecation != nil {
+		headers["Deprecation"] = fmt.Sprintf("@%d", rc.Deprecation.Unix())
+	}
+	if rc.Sunset != nil {
+		headers["Sunset"] = rc.Sunset.UTC().Format(http.TimeFormat)
+	}
+	if rc.Link != "" && (rc.Deprecation != nil || rc.Sunset != nil) {
+		headers["Link"] = fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, rc.Link)
+	}
+	return headers
+}
+
+func addRouteHeaders(response *events.APIGatewayProxyResponse, event any) {
+	route := ""
+	if routed, ok := event.(Routed); ok {
+		route = routed.RouteKey()
+	}
+	headers := RouteHeaders(route)
+	if len(headers) == 0 {
+		return
+	}
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	for name, value := range headers {
+		response.Headers[name] = value
+	}
+}
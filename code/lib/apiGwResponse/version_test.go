@@ -0,0 +1,66 @@
+package apiGwResponse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type routedEvent string
+
+func (e routedEvent) RouteKey() string {
+	return string(e)
+}
+
+func TestRouteHeaders(t *testing.T) {
+	deprecation := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	err := ConfigureRoutes(map[string]RouteConfig{
+		"/unstable/v2/dmq/make": {Version: "unstable-v2", Deprecation: &deprecation, Sunset: &sunset, Link: "https://example.com/migrate"},
+		"/v3/dmq/make":          {Version: "v3"},
+	}, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureRoutes(map[string]RouteConfig{}, "")
+
+	handler := Wrap(&testLogger{}, func(ctx context.Context, event routedEvent) (events.APIGatewayProxyResponse, error) {
+		return OkResponse("ok")
+	})
+	response, _ := handler(context.Background(), "/unstable/v2/dmq/make")
+	want := map[string]string{
+		"API-Version":                   "unstable-v2",
+		"Deprecation":                   "@1767225600",
+		"Sunset":                        "Wed, 01 Jul 2026 00:00:00 GMT",
+		"Link":                          `<https://example.com/migrate>; rel="deprecation"; type="text/html"`,
+		"Access-Control-Allow-Origin":   "*",
+		"Access-Control-Expose-Headers": "API-Version, Deprecation, Sunset, Link",
+		"Content-Type":                  "application/json",
+	}
+	for name, value := range want {
+		if response.Headers[name] != value {
+			t.Errorf("%s = %q, want %q", name, response.Headers[name], value)
+		}
+	}
+
+	response, _ = handler(context.Background(), "/v3/dmq/make")
+	if response.Headers["API-Version"] != "v3" || response.Headers["Deprecation"] != "" || response.Headers["Sunset"] != "" {
+		t.Errorf("unexpected headers %v", response.Headers)
+	}
+}
+
+func TestConfigureRoutesInvalid(t *testing.T) {
+	deprecation := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := deprecation.AddDate(0, -1, 0)
+	configs := []map[string]RouteConfig{
+		{"/a": {}},
+		{"/a": {Version: "v1", Deprecation: &deprecation, Sunset: &sunset}},
+	}
+	for _, config := range configs {
+		if ConfigureRoutes(config, "") == nil {
+			t.Errorf("config %v should be rejected", config)
+		}
+	}
+}
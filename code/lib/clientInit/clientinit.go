@@ -3,7 +3,9 @@ package clientInit
 import (
 	"context"
 	"errors"
+	"net/http"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/docs/v1"
 	"google.golang.org/api/drive/v3"
@@ -79,6 +81,15 @@ func InitGSheet(ctx context.Context, init GInit) (*sheets.Service, GInit, error)
 	return service, GInit{ Credentials: creds }, nil
 }
 
+// Authorized HTTP client for Google APIs not covered by the client libraries (e.g. resumable upload sessions)
+func InitGHttpClient(ctx context.Context, init GInit) (*http.Client, GInit, error) {
+	creds, err := initGoogleCredentials(ctx, init)
+	if err != nil {
+		return nil, GInit{}, err
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), GInit{ Credentials: creds }, nil
+}
+
 func initAwsConfig(ctx context.Context, cfg *aws.Config) (*aws.Config, error) {
 	if cfg == nil {
 		cfg1, err := config.LoadDefaultConfig(ctx)
@@ -0,0 +1,120 @@
+package driveList
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Largest page Drive returns, fewer round trips than the default of 100
+	DefaultPageSize int64 = 1000
+	DefaultFields         = "id, name, mimeType"
+)
+
+// Return Stop from the callback of Each to end the listing early without an error
+var Stop = errors.New("stop listing")
+
+type Query struct {
+	// Drive search query, e.g. "'<folderId>' in parents and trashed = false"
+	Q string
+	// Field mask of a single file, e.g. "id, name, shortcutDetails". Empty means DefaultFields.
+	Fields string
+	// Zero means DefaultPageSize
+	PageSize int64
+	// Limits the search to one shared drive. Empty searches all drives.
+	DriveId string
+	OrderBy string
+}
+
+// Lists folder children, trashed files are left out
+func InFolder(folderId string, extraQ string) string {
+	q := fmt.Sprintf("'%s' in parents and trashed = false", EscapeQuery(folderId))
+	if extraQ != "" {
+		q += " and " + extraQ
+	}
+	return q
+}
+
+// Escapes value used inside single quotes of a query
+func EscapeQuery(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `'`, `\'`)
+}
+
+func (q Query) call(ctx context.Context, svc *drive.Service) *drive.FilesListCall {
+	fields := q.Fields
+	if fields == "" {
+		fields = DefaultFields
+	}
+	pageSize := q.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+
+	call := svc.Files.List().
+		Context(ctx).
+		Q(q.Q).
+		Fields(googleapi.Field("nextPageToken, files(" + fields + ")")).
+		PageSize(pageSize).
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true)
+	if q.DriveId != "" {
+		call = call.Corpora("drive").DriveId(q.DriveId)
+	} else {
+		call = call.Corpora("allDrives")
+	}
+	if q.OrderBy != "" {
+		call = call.OrderBy(q.OrderBy)
+	}
+	return call
+}
+
+// Calls fn for every file matching the query, following nextPageToken until the last page.
+// Listing ends early when fn returns Stop (Each then returns nil) or any other error.
+func Each(ctx context.Context, svc *drive.Service, q Query, fn func(*drive.File) error) error {
+	call := q.call(ctx, svc)
+	for {
+		page, err := call.Do()
+		if err != nil {
+			return errors.Join(fmt.Errorf("Error listing drive files q=%s", q.Q), err)
+		}
+		for _, file := range page.Files {
+			err = fn(file)
+			if errors.Is(err, Stop) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		call.PageToken(page.NextPageToken)
+	}
+}
+
+// Returns all files matching the query
+func All(ctx context.Context, svc *drive.Service, q Query) ([]*drive.File, error) {
+	files := []*drive.File{}
+	err := Each(ctx, svc, q, func(file *drive.File) error {
+		files = append(files, file)
+		return nil
+	})
+	return files, err
+}
+
+// Returns the first file matching the query, nil when there is none
+func First(ctx context.Context, svc *drive.Service, q Query) (*drive.File, error) {
+	var first *drive.File
+	err := Each(ctx, svc, q, func(file *drive.File) error {
+		first = file
+		return Stop
+	})
+	return first, err
+}
@@ -0,0 +1,101 @@
+package driveList
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// Serves three pages of two files each
+func pagedServer(t *testing.T, requests *int) *httptest.Server {
+	pages := map[string]drive.FileList{
+		"": {
+			Files:         []*drive.File{{Id: "1"}, {Id: "2"}},
+			NextPageToken: "p2",
+		},
+		"p2": {
+			Files:         []*drive.File{{Id: "3"}, {Id: "4"}},
+			NextPageToken: "p3",
+		},
+		"p3": {
+			Files: []*drive.File{{Id: "5"}, {Id: "6"}},
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if got := r.URL.Query().Get("pageSize"); got != "2" {
+			t.Errorf("pageSize = %q, want %q", got, "2")
+		}
+		if got := r.URL.Query().Get("fields"); got != "nextPageToken, files(id)" {
+			t.Errorf("fields = %q", got)
+		}
+		page, ok := pages[r.URL.Query().Get("pageToken")]
+		if !ok {
+			t.Fatalf("unexpected pageToken %q", r.URL.Query().Get("pageToken"))
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func TestAllFollowsPages(t *testing.T) {
+	requests := 0
+	server := pagedServer(t, &requests)
+	defer server.Close()
+
+	ctx := context.Background()
+	svc, err := drive.NewService(ctx, option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := All(ctx, svc, Query{Q: InFolder("folder", ""), Fields: "id", PageSize: 2})
+	if err != nil {
+		t.Fatalf("All() error = %v", err)
+	}
+	if len(files) != 6 {
+		t.Errorf("All() returned %d files, want 6", len(files))
+	}
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestEachStop(t *testing.T) {
+	requests := 0
+	server := pagedServer(t, &requests)
+	defer server.Close()
+
+	ctx := context.Background()
+	svc, err := drive.NewService(ctx, option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []string
+	err = Each(ctx, svc, Query{Q: InFolder("folder", ""), Fields: "id", PageSize: 2}, func(file *drive.File) error {
+		seen = append(seen, file.Id)
+		if file.Id == "3" {
+			return Stop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each() error = %v", err)
+	}
+	if len(seen) != 3 || requests != 2 {
+		t.Errorf("seen = %v after %d requests, want 3 files after 2 requests", seen, requests)
+	}
+}
+
+func TestInFolder(t *testing.T) {
+	got := InFolder(`it's`, "mimeType = 'x'")
+	want := `'it\'s' in parents and trashed = false and mimeType = 'x'`
+	if got != want {
+		t.Errorf("InFolder() = %q, want %q", got, want)
+	}
+}
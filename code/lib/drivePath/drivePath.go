@@ -0,0 +1,230 @@
+// Resolves human readable paths like "Photo Archives/SQ Photos 2025/01 Jan 2025" to Drive IDs.
+// Shortcuts are followed transparently and listings are cached, so repeated lookups during
+// a Lambda invocation (and across warm invocations) don't hit the Drive API again.
+package drivePath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"lambdalib/driveList"
+)
+
+const (
+	FolderMime   = "application/vnd.google-apps.folder"
+	ShortcutMime = "application/vnd.google-apps.shortcut"
+	DocumentMime = "application/vnd.google-apps.document"
+
+	DefaultTTL = 5 * time.Minute
+	// Listings kept at most, the ones expiring first are dropped to make room
+	DefaultMaxEntries = 1000
+)
+
+// ErrNotFound is returned when a path segment does not exist
+type ErrNotFound struct {
+	ParentId string
+	Name     string
+}
+
+func (e *ErrNotFound) Error() string {
+	return fmt.Sprintf("Nothing named %q found in %s", e.Name, e.ParentId)
+}
+
+type cacheEntry struct {
+	files   []*drive.File
+	expires time.Time
+}
+
+// Resolver caches folder listings for TTL. A warm Lambda keeps the cache between invocations,
+// so after a folder is renamed or moved a lookup can return its old ID (or miss the new one) until the entry expires.
+// Use FreshChildren when a stale listing is not acceptable.
+type Resolver struct {
+	svc *drive.Service
+	// How long a folder listing is reused
+	TTL time.Duration
+	// Most listings kept in the cache
+	MaxEntries int
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+func NewResolver(svc *drive.Service) *Resolver {
+	return &Resolver{
+		svc:        svc,
+		TTL:        DefaultTTL,
+		MaxEntries: DefaultMaxEntries,
+		cache:      map[string]cacheEntry{},
+	}
+}
+
+// Splits path into segments, ignoring empty segments from leading, trailing or doubled slashes
+func Split(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.TrimSpace(segment)
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Shortcut is replaced by its target. Name of the shortcut is kept and ShortcutDetails stays set,
+// so callers can tell the file was reached through a shortcut.
+func follow(file *drive.File) *drive.File {
+	if file.MimeType != ShortcutMime || file.ShortcutDetails == nil {
+		return file
+	}
+	target := *file
+	target.Id = file.ShortcutDetails.TargetId
+	target.MimeType = file.ShortcutDetails.TargetMimeType
+	return &target
+}
+
+// Lists all non-trashed children of folderId with shortcuts followed.
+// When mimeType is set, only children (or shortcut targets) of that type are returned.
+// driveId limits the search to one shared drive, empty searches all drives.
+func (r *Resolver) Children(ctx context.Context, driveId string, folderId string, mimeType string) ([]*drive.File, error) {
+	return r.children(ctx, driveId, folderId, mimeType, false)
+}
+
+// Like Children, but always lists the folder again. The new listing replaces the cached one.
+func (r *Resolver) FreshChildren(ctx context.Context, driveId string, folderId string, mimeType string) ([]*drive.File, error) {
+	return r.children(ctx, driveId, folderId, mimeType, true)
+}
+
+func (r *Resolver) children(ctx context.Context, driveId string, folderId string, mimeType string, fresh bool) ([]*drive.File, error) {
+	cacheKey := driveId + "/" + folderId
+	var files []*drive.File
+	ok := false
+	if !fresh {
+		files, ok = r.cached(cacheKey)
+	}
+	if !ok {
+		listed, err := driveList.All(ctx, r.svc, driveList.Query{
+			Q:       driveList.InFolder(folderId, ""),
+			Fields:  "id, name, mimeType, size, md5Checksum, shortcutDetails(targetId, targetMimeType)",
+			DriveId: driveId,
+		})
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Error listing drive folder: %s", folderId), err)
+		}
+		files = make([]*drive.File, 0, len(listed))
+		for _, file := range listed {
+			files = append(files, follow(file))
+		}
+		r.store(cacheKey, files)
+	}
+
+	if mimeType == "" {
+		return files, nil
+	}
+	var filtered []*drive.File
+	for _, file := range files {
+		if file.MimeType == mimeType {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered, nil
+}
+
+// Returns the first child named name. Returns *ErrNotFound when there is none.
+func (r *Resolver) Child(ctx context.Context, driveId string, folderId string, name string, mimeType string) (*drive.File, error) {
+	children, err := r.Children(ctx, driveId, folderId, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if child.Name == name {
+			return child, nil
+		}
+	}
+	return nil, &ErrNotFound{ParentId: folderId, Name: name}
+}
+
+// Resolves slash separated path relative to rootId. Empty rootId means root of the shared drive driveId.
+// All segments except the last one must be folders (or shortcuts to folders).
+func (r *Resolver) Resolve(ctx context.Context, driveId string, rootId string, path string) (*drive.File, error) {
+	if rootId == "" {
+		if driveId == "" {
+			return nil, errors.New("Either root folder or shared drive is required to resolve a path")
+		}
+		// Root folder of a shared drive has the same ID as the drive
+		rootId = driveId
+	}
+
+	current := &drive.File{Id: rootId, MimeType: FolderMime}
+	segments := Split(path)
+	for i, segment := range segments {
+		mimeType := FolderMime
+		if i == len(segments)-1 {
+			mimeType = ""
+		}
+		next, err := r.Child(ctx, driveId, current.Id, segment, mimeType)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Error resolving path %q", path), err)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// Resolves path to a folder ID. Convenience for events which accept either an ID or a path.
+func (r *Resolver) FolderId(ctx context.Context, driveId string, folderId string, path string) (string, error) {
+	if path == "" {
+		return folderId, nil
+	}
+	folder, err := r.Resolve(ctx, driveId, folderId, path)
+	if err != nil {
+		return "", err
+	}
+	if folder.MimeType != FolderMime {
+		return "", fmt.Errorf("Path %q is not a folder, but %s", path, folder.MimeType)
+	}
+	return folder.Id, nil
+}
+
+func (r *Resolver) cached(key string) ([]*drive.File, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(r.cache, key)
+		return nil, false
+	}
+	return entry.files, true
+}
+
+// Drops expired entries first, then the ones expiring soonest while the cache is full
+func (r *Resolver) store(key string, files []*drive.File) {
+	if r.TTL <= 0 || r.MaxEntries <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for cachedKey, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, cachedKey)
+		}
+	}
+	delete(r.cache, key)
+	for len(r.cache) >= r.MaxEntries {
+		oldestKey := ""
+		var oldest time.Time
+		for cachedKey, entry := range r.cache {
+			if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = cachedKey, entry.expires
+			}
+		}
+		delete(r.cache, oldestKey)
+	}
+	r.cache[key] = cacheEntry{files: files, expires: now.Add(r.TTL)}
+}
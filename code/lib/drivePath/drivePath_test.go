@@ -0,0 +1,285 @@
+package drivePath
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+var parentQuery = regexp.MustCompile(`'([^']*)' in parents`)
+
+// Shared drive "drive" with Photo Archives/SQ Photos 2025/01 Jan 2025 and a shortcut to the archive in "Links"
+func folderServer(t *testing.T, requests map[string]int) *httptest.Server {
+	folders := map[string][]*drive.File{
+		"drive": {
+			{Id: "archives", Name: "Photo Archives", MimeType: FolderMime},
+			{Id: "links", Name: "Links", MimeType: FolderMime},
+			{Id: "readme", Name: "Readme", MimeType: DocumentMime},
+		},
+		"archives": {
+			{Id: "y2025", Name: "SQ Photos 2025", MimeType: FolderMime},
+			{Id: "notes", Name: "SQ Photos 2025", MimeType: DocumentMime},
+		},
+		"y2025": {
+			{Id: "jan", Name: "01 Jan 2025", MimeType: FolderMime},
+		},
+		"links": {
+			{
+				Id:              "shortcut",
+				Name:            "Archive link",
+				MimeType:        ShortcutMime,
+				ShortcutDetails: &drive.FileShortcutDetails{TargetId: "archives", TargetMimeType: FolderMime},
+			},
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := parentQuery.FindStringSubmatch(r.URL.Query().Get("q"))
+		if match == nil {
+			t.Fatalf("unexpected query %q", r.URL.Query().Get("q"))
+		}
+		requests[match[1]]++
+		json.NewEncoder(w).Encode(drive.FileList{Files: folders[match[1]]})
+	}))
+}
+
+func newTestResolver(t *testing.T, requests map[string]int) *Resolver {
+	server := folderServer(t, requests)
+	t.Cleanup(server.Close)
+	svc, err := drive.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewResolver(svc)
+}
+
+func TestSplit(t *testing.T) {
+	type tc struct {
+		name     string
+		path     string
+		expected []string
+	}
+
+	tests := []tc{
+		{name: "empty", path: "", expected: nil},
+		{name: "only slashes", path: "//", expected: nil},
+		{name: "single", path: "Photo Archives", expected: []string{"Photo Archives"}},
+		{name: "nested", path: "Photo Archives/SQ Photos 2025", expected: []string{"Photo Archives", "SQ Photos 2025"}},
+		{name: "leading and trailing slash", path: "/Photo Archives/", expected: []string{"Photo Archives"}},
+		{name: "doubled slash and spaces", path: " a //  b ", expected: []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Split(test.path)
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Split(%q) = %q, want %q", test.path, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFollow(t *testing.T) {
+	type tc struct {
+		name     string
+		file     *drive.File
+		expected *drive.File
+	}
+
+	details := &drive.FileShortcutDetails{TargetId: "target", TargetMimeType: FolderMime}
+	tests := []tc{
+		{
+			name:     "folder",
+			file:     &drive.File{Id: "folder", Name: "Folder", MimeType: FolderMime},
+			expected: &drive.File{Id: "folder", Name: "Folder", MimeType: FolderMime},
+		},
+		{
+			name:     "shortcut",
+			file:     &drive.File{Id: "shortcut", Name: "Link", MimeType: ShortcutMime, ShortcutDetails: details},
+			expected: &drive.File{Id: "target", Name: "Link", MimeType: FolderMime, ShortcutDetails: details},
+		},
+		{
+			name:     "shortcut without details",
+			file:     &drive.File{Id: "shortcut", Name: "Link", MimeType: ShortcutMime},
+			expected: &drive.File{Id: "shortcut", Name: "Link", MimeType: ShortcutMime},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := *test.file
+			got := follow(test.file)
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("follow() = %+v, want %+v", got, test.expected)
+			}
+			if !reflect.DeepEqual(*test.file, original) {
+				t.Errorf("follow() changed its argument to %+v", test.file)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	type tc struct {
+		name     string
+		driveId  string
+		rootId   string
+		path     string
+		expected string
+		notFound bool
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "empty path is the root", driveId: "drive", path: "", expected: "drive"},
+		{name: "drive root", driveId: "drive", path: "Photo Archives/SQ Photos 2025/01 Jan 2025", expected: "jan"},
+		{name: "relative to folder", driveId: "drive", rootId: "archives", path: "SQ Photos 2025", expected: "y2025"},
+		{name: "through shortcut", driveId: "drive", path: "Links/Archive link/SQ Photos 2025", expected: "y2025"},
+		{name: "last segment can be a file", driveId: "drive", path: "Readme", expected: "readme"},
+		{name: "file is not a folder", driveId: "drive", path: "Readme/Other", notFound: true, wantErr: true},
+		{name: "missing segment", driveId: "drive", path: "Photo Archives/SQ Photos 2024", notFound: true, wantErr: true},
+		{name: "no root", path: "Photo Archives", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := newTestResolver(t, map[string]int{})
+			got, err := resolver.Resolve(context.Background(), test.driveId, test.rootId, test.path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %t", test.path, err, test.wantErr)
+			}
+			var notFound *ErrNotFound
+			if errors.As(err, &notFound) != test.notFound {
+				t.Errorf("Resolve(%q) error = %v, want ErrNotFound %t", test.path, err, test.notFound)
+			}
+			if err == nil && got.Id != test.expected {
+				t.Errorf("Resolve(%q) = %s, want %s", test.path, got.Id, test.expected)
+			}
+		})
+	}
+}
+
+func TestChild(t *testing.T) {
+	type tc struct {
+		name     string
+		folderId string
+		child    string
+		mimeType string
+		expected string
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "any type returns the first", folderId: "archives", child: "SQ Photos 2025", expected: "y2025"},
+		{name: "folder only", folderId: "archives", child: "SQ Photos 2025", mimeType: FolderMime, expected: "y2025"},
+		{name: "document only", folderId: "archives", child: "SQ Photos 2025", mimeType: DocumentMime, expected: "notes"},
+		{name: "shortcut target type", folderId: "links", child: "Archive link", mimeType: FolderMime, expected: "archives"},
+		{name: "missing", folderId: "archives", child: "SQ Photos 2024", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := newTestResolver(t, map[string]int{})
+			got, err := resolver.Child(context.Background(), "drive", test.folderId, test.child, test.mimeType)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Child(%q) error = %v, wantErr %t", test.child, err, test.wantErr)
+			}
+			if err == nil && got.Id != test.expected {
+				t.Errorf("Child(%q) = %s, want %s", test.child, got.Id, test.expected)
+			}
+		})
+	}
+}
+
+func TestChildrenCache(t *testing.T) {
+	requests := map[string]int{}
+	resolver := newTestResolver(t, requests)
+	ctx := context.Background()
+
+	for range 3 {
+		_, err := resolver.Children(ctx, "drive", "archives", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests["archives"] != 1 {
+		t.Errorf("requests = %d, want 1 with cache", requests["archives"])
+	}
+
+	resolver.cache["drive/archives"] = cacheEntry{expires: time.Now().Add(-time.Second)}
+	_, err := resolver.Children(ctx, "drive", "archives", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests["archives"] != 2 {
+		t.Errorf("requests = %d, want 2 after expiry", requests["archives"])
+	}
+
+	resolver.TTL = 0
+	resolver.cache = map[string]cacheEntry{}
+	for range 2 {
+		_, err = resolver.Children(ctx, "drive", "archives", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests["archives"] != 4 {
+		t.Errorf("requests = %d, want 4 without cache", requests["archives"])
+	}
+}
+
+func TestStoreEvicts(t *testing.T) {
+	resolver := &Resolver{TTL: time.Minute, MaxEntries: 2, cache: map[string]cacheEntry{}}
+	resolver.cache["expired"] = cacheEntry{expires: time.Now().Add(-time.Second)}
+
+	resolver.store("first", nil)
+	if _, ok := resolver.cache["expired"]; ok {
+		t.Error("expired entry kept after store")
+	}
+
+	resolver.cache["first"] = cacheEntry{expires: time.Now().Add(time.Second)}
+	resolver.store("second", nil)
+	resolver.store("third", nil)
+	if len(resolver.cache) != 2 {
+		t.Errorf("cache size = %d, want 2", len(resolver.cache))
+	}
+	if _, ok := resolver.cache["first"]; ok {
+		t.Error("entry expiring first kept in full cache")
+	}
+
+	resolver.store("third", nil)
+	if _, ok := resolver.cache["second"]; !ok {
+		t.Error("storing a cached key evicted another entry")
+	}
+}
+
+func TestFreshChildren(t *testing.T) {
+	requests := map[string]int{}
+	resolver := newTestResolver(t, requests)
+	ctx := context.Background()
+
+	resolver.cache["drive/archives"] = cacheEntry{expires: time.Now().Add(time.Minute)}
+	files, err := resolver.FreshChildren(ctx, "drive", "archives", FolderMime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Id != "y2025" {
+		t.Errorf("FreshChildren() = %v, want y2025", files)
+	}
+
+	_, err = resolver.Children(ctx, "drive", "archives", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests["archives"] != 1 {
+		t.Errorf("requests = %d, want 1, the fresh listing is cached", requests["archives"])
+	}
+}
@@ -0,0 +1,146 @@
+package fileTransfer
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ErrChecksumMismatch is returned when the transferred content differs from the source.
+// Lambda reports the type name as errorType, so a state machine can retry on "ErrChecksumMismatch".
+type ErrChecksumMismatch struct {
+	Location string
+	Kind     string
+	Expected string
+	Actual   string
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("Checksum mismatch at %s: %s expected=%s actual=%s", e.Location, e.Kind, e.Expected, e.Actual)
+}
+
+// Computes checksums of all bytes written to it. Used with io.TeeReader on the transferred stream.
+type digest struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+func newDigest() *digest {
+	return &digest{
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.md5.Write(p)
+	d.sha256.Write(p)
+	d.size += int64(len(p))
+	return len(p), nil
+}
+
+func (d *digest) md5Hex() string {
+	return hex.EncodeToString(d.md5.Sum(nil))
+}
+
+func (d *digest) sha256Hex() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
+
+func (d *digest) sha256Base64() string {
+	return base64.StdEncoding.EncodeToString(d.sha256.Sum(nil))
+}
+
+// ETag of an object uploaded in a single part is MD5 of its content. Multipart ETags contain "-N" and are not comparable.
+func etagMd5(etag *string) string {
+	if etag == nil {
+		return ""
+	}
+	value := strings.Trim(*etag, `"`)
+	if strings.Contains(value, "-") {
+		return ""
+	}
+	return value
+}
+
+// Full object SHA256 checksum of S3 object. Composite (multipart) checksums are not comparable.
+func s3Sha256Hex(checksum *string) string {
+	if checksum == nil || *checksum == "" || strings.Contains(*checksum, "-") {
+		return ""
+	}
+	raw, err := base64.StdEncoding.DecodeString(*checksum)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
+
+// SHA256 of the S3 object computed by streaming it. Used when the object metadata has no comparable checksum, e.g. multipart uploads.
+func streamS3Sha256(ctx context.Context, s3c *s3.Client, s3Bucket string, s3Key string) (string, error) {
+	s3File, err := s3c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s3Bucket,
+		Key:    &s3Key,
+	})
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("Error downloading file from s3=%s key=%s", s3Bucket, s3Key), err)
+	}
+	defer s3File.Body.Close()
+
+	sum := sha256.New()
+	_, err = io.Copy(sum, s3File.Body)
+	if err != nil {
+		return "", transient(errors.Join(fmt.Errorf("Error reading file from s3=%s key=%s", s3Bucket, s3Key), err))
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// Compares checksum pairs. Empty values are not known and skipped.
+func compareChecksum(location string, kind string, expected string, actual string) error {
+	if expected == "" || actual == "" {
+		return nil
+	}
+	if !strings.EqualFold(expected, actual) {
+		return &ErrChecksumMismatch{
+			Location: location,
+			Kind:     kind,
+			Expected: expected,
+			Actual:   actual,
+		}
+	}
+	return nil
+}
+
+func compareSize(location string, expected int64, actual int64) error {
+	if expected < 0 || actual < 0 {
+		return nil
+	}
+	if expected != actual {
+		return &ErrChecksumMismatch{
+			Location: location,
+			Kind:     "size",
+			Expected: fmt.Sprint(expected),
+			Actual:   fmt.Sprint(actual),
+		}
+	}
+	return nil
+}
+
+// Returns the first failed comparison. Not joined, to keep *ErrChecksumMismatch as the reported error type.
+func firstMismatch(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package fileTransfer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestEtagMd5(t *testing.T) {
+	type tc struct {
+		name     string
+		etag     *string
+		expected string
+	}
+
+	tests := []tc{
+		{name: "missing", etag: nil, expected: ""},
+		{name: "single part", etag: aws.String(`"9e107d9d372bb6826bd81d3542a419d6"`), expected: "9e107d9d372bb6826bd81d3542a419d6"},
+		{name: "unquoted", etag: aws.String("9e107d9d372bb6826bd81d3542a419d6"), expected: "9e107d9d372bb6826bd81d3542a419d6"},
+		{name: "multipart is skipped", etag: aws.String(`"d41d8cd98f00b204e9800998ecf8427e-3"`), expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := etagMd5(test.etag)
+			if got != test.expected {
+				t.Errorf("etagMd5() = %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestS3Sha256Hex(t *testing.T) {
+	type tc struct {
+		name     string
+		checksum *string
+		expected string
+	}
+
+	tests := []tc{
+		{name: "missing", checksum: nil, expected: ""},
+		{name: "empty", checksum: aws.String(""), expected: ""},
+		{
+			name:     "full object",
+			checksum: aws.String("47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="),
+			expected: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{name: "composite is skipped", checksum: aws.String("47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=-3"), expected: ""},
+		{name: "not base64", checksum: aws.String("not base64!"), expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := s3Sha256Hex(test.checksum)
+			if got != test.expected {
+				t.Errorf("s3Sha256Hex() = %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFirstMismatch(t *testing.T) {
+	type tc struct {
+		name     string
+		errs     []error
+		expected string
+	}
+
+	location := "s3=bucket key=key -> drive file=id"
+	tests := []tc{
+		{name: "nothing compared", errs: nil, expected: ""},
+		{
+			name: "all match",
+			errs: []error{
+				compareSize(location, 10, 10),
+				compareChecksum(location, "md5", "ABC", "abc"),
+			},
+			expected: "",
+		},
+		{
+			name: "unknown values are skipped",
+			errs: []error{
+				compareSize(location, -1, 10),
+				compareChecksum(location, "md5", "", "abc"),
+				compareChecksum(location, "sha256", "abc", ""),
+			},
+			expected: "",
+		},
+		{
+			name: "first of several",
+			errs: []error{
+				compareSize(location, 10, 10),
+				compareChecksum(location, "md5", "abc", "def"),
+				compareChecksum(location, "sha256", "123", "456"),
+			},
+			expected: "md5",
+		},
+		{
+			name: "size",
+			errs: []error{
+				compareSize(location, 10, 11),
+				compareChecksum(location, "md5", "abc", "def"),
+			},
+			expected: "size",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := firstMismatch(test.errs...)
+			if test.expected == "" {
+				if err != nil {
+					t.Errorf("firstMismatch() = %v, want nil", err)
+				}
+				return
+			}
+			var mismatch *ErrChecksumMismatch
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("firstMismatch() = %v, want *ErrChecksumMismatch", err)
+			}
+			if mismatch.Kind != test.expected {
+				t.Errorf("firstMismatch() kind = %q, want %q", mismatch.Kind, test.expected)
+			}
+		})
+	}
+}
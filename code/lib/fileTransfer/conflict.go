@@ -0,0 +1,236 @@
+package fileTransfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"lambdalib/driveList"
+)
+
+// Names "name (1).ext" to "name (1000).ext" are tried by ConflictSuffixCounter before it fails
+const maxSuffixCounter = 1000
+
+// What to do when a file with the same name already exists in the destination folder
+type ConflictPolicy string
+
+const (
+	// Always create a new file, duplicates by name are allowed (Drive default)
+	ConflictCreateNew ConflictPolicy = "createNew"
+	// Upload a new file and trash all other files with the same name
+	ConflictReplace ConflictPolicy = "replace"
+	// Upload content as a new revision of the existing file, keeping its ID and link.
+	// With several files of the name the most recently modified one is updated.
+	ConflictNewRevision ConflictPolicy = "newRevision"
+	// Do nothing when a file with the same name and checksum exists, otherwise create a new file
+	ConflictSkipIdentical ConflictPolicy = "skipIfIdentical"
+	// Create a new file named "name (1).ext", "name (2).ext", ... when the name is taken
+	ConflictSuffixCounter ConflictPolicy = "suffixWithCounter"
+)
+
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(policy); p {
+	case "":
+		return ConflictCreateNew, nil
+	case ConflictCreateNew, ConflictReplace, ConflictNewRevision, ConflictSkipIdentical, ConflictSuffixCounter:
+		return p, nil
+	}
+	return "", fmt.Errorf("Unknown conflict policy: %s", policy)
+}
+
+type UploadResult struct {
+	FileId string `json:"fileId"`
+	Name   string `json:"name"`
+	// True when nothing was uploaded because an identical file exists
+	Skipped bool `json:"skipped,omitempty"`
+	// IDs of files trashed by ConflictReplace
+	Replaced []string `json:"replaced,omitempty"`
+}
+
+type uploadPlan struct {
+	name string
+	// When set, the content is uploaded as a new revision of this file
+	updateId string
+	// When set, no upload is needed
+	identicalId string
+}
+
+// Checksums of the source, known before the upload. Empty when unknown.
+type sourceChecksum struct {
+	md5    string
+	sha256 string
+	size   int64
+	// Computes sha256 when it is unknown, called only if a file of the same name and size exists
+	streamSha256 func(ctx context.Context) (string, error)
+}
+
+func (source sourceChecksum) matches(file *drive.File) bool {
+	return (source.md5 != "" && strings.EqualFold(source.md5, file.Md5Checksum)) ||
+		(source.sha256 != "" && strings.EqualFold(source.sha256, file.Sha256Checksum))
+}
+
+// Finds a file with the same content. Multipart S3 objects have no comparable checksum, their sha256 is streamed.
+func findIdentical(ctx context.Context, source sourceChecksum, existing []*drive.File) (string, error) {
+	for _, file := range existing {
+		if source.matches(file) {
+			return file.Id, nil
+		}
+	}
+	if source.md5 != "" || source.sha256 != "" || source.streamSha256 == nil {
+		return "", nil
+	}
+
+	sameSize := false
+	for _, file := range existing {
+		sameSize = sameSize || (file.Size == source.size && file.Sha256Checksum != "")
+	}
+	if !sameSize {
+		return "", nil
+	}
+	sha, err := source.streamSha256(ctx)
+	if err != nil {
+		return "", err
+	}
+	source.sha256 = sha
+	for _, file := range existing {
+		if source.matches(file) {
+			return file.Id, nil
+		}
+	}
+	return "", nil
+}
+
+func listByName(ctx context.Context, driveSvc *drive.Service, folderId string, name string) ([]*drive.File, error) {
+	files, err := driveList.All(ctx, driveSvc, driveList.Query{
+		Q:      driveList.InFolder(folderId, fmt.Sprintf("name = '%s'", driveList.EscapeQuery(name))),
+		Fields: "id, name, size, md5Checksum, sha256Checksum, modifiedTime",
+	})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("Error listing folder=%s name=%s", folderId, name), err)
+	}
+	return files, nil
+}
+
+func planUpload(ctx context.Context, driveSvc *drive.Service, folderId string, fileName string, policy ConflictPolicy, source sourceChecksum) (uploadPlan, error) {
+	plan := uploadPlan{name: fileName}
+	if policy == ConflictCreateNew || policy == ConflictReplace {
+		// Replace trashes duplicates only after the upload succeeded
+		return plan, nil
+	}
+
+	existing, err := listByName(ctx, driveSvc, folderId, fileName)
+	if err != nil {
+		return plan, err
+	}
+	if len(existing) == 0 {
+		return plan, nil
+	}
+
+	switch policy {
+	case ConflictNewRevision:
+		newest, err := newestFile(existing)
+		if err != nil {
+			return plan, errors.Join(fmt.Errorf("Error choosing file to update folder=%s name=%s", folderId, fileName), err)
+		}
+		plan.updateId = newest.Id
+
+	case ConflictSkipIdentical:
+		plan.identicalId, err = findIdentical(ctx, source, existing)
+		if err != nil {
+			return plan, err
+		}
+
+	case ConflictSuffixCounter:
+		plan.name, err = freeSuffixName(ctx, driveSvc, folderId, fileName)
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// Most recently modified file. Drive lists files of the same name in no particular order,
+// so a tie or an unknown time is an error rather than a random pick.
+func newestFile(files []*drive.File) (*drive.File, error) {
+	var newest *drive.File
+	var newestTime time.Time
+	tie := false
+	for _, file := range files {
+		modified, err := time.Parse(time.RFC3339, file.ModifiedTime)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Unknown modified time of file %s", file.Id), err)
+		}
+		switch {
+		case newest == nil || modified.After(newestTime):
+			newest, newestTime, tie = file, modified, false
+		case modified.Equal(newestTime):
+			tie = true
+		}
+	}
+	if tie {
+		return nil, fmt.Errorf("Several files were modified last at %s, file %s is not the only candidate", newestTime.Format(time.RFC3339), newest.Id)
+	}
+	return newest, nil
+}
+
+// First free name "name (N).ext" found with a single listing.
+// Drive matches "name contains" against the start of the name, so the listing holds every candidate.
+func freeSuffixName(ctx context.Context, driveSvc *drive.Service, folderId string, fileName string) (string, error) {
+	ext := path.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	files, err := driveList.All(ctx, driveSvc, driveList.Query{
+		Q:      driveList.InFolder(folderId, fmt.Sprintf("name contains '%s'", driveList.EscapeQuery(base))),
+		Fields: "id, name",
+	})
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("Error listing folder=%s names starting with %s", folderId, base), err)
+	}
+
+	taken := map[string]bool{}
+	for _, file := range files {
+		taken[file.Name] = true
+	}
+	for i := 1; i <= maxSuffixCounter; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !taken[candidate] {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("Names %s (1)%s to (%d) are all taken in folder=%s", base, ext, maxSuffixCounter, folderId)
+}
+
+// Trashes files with the same name as the uploaded one, except the uploaded one.
+func removeDuplicates(ctx context.Context, driveSvc *drive.Service, folderId string, fileName string, keepId string) ([]string, error) {
+	existing, err := listByName(ctx, driveSvc, folderId, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, file := range existing {
+		if file.Id == keepId {
+			continue
+		}
+		// Trashed, not deleted, so a wrong replace can be undone
+		_, err = driveSvc.Files.Update(file.Id, &drive.File{Trashed: true}).SupportsAllDrives(true).Context(ctx).Do()
+		if err != nil {
+			return removed, errors.Join(fmt.Errorf("Error removing replaced file %s", file.Id), err)
+		}
+		removed = append(removed, file.Id)
+	}
+	return removed, nil
+}
+
+// Removes content which failed verification. When the upload was a new revision, only that revision is removed.
+func discardUpload(ctx context.Context, driveSvc *drive.Service, file *drive.File) error {
+	revisions, err := driveSvc.Revisions.List(file.Id).Fields("revisions(id)").Context(ctx).Do()
+	if err == nil && len(revisions.Revisions) > 1 && file.HeadRevisionId != "" {
+		return driveSvc.Revisions.Delete(file.Id, file.HeadRevisionId).Context(ctx).Do()
+	}
+	return driveSvc.Files.Delete(file.Id).SupportsAllDrives(true).Context(ctx).Do()
+}
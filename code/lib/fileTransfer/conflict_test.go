@@ -0,0 +1,230 @@
+package fileTransfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+func TestFindIdentical(t *testing.T) {
+	type tc struct {
+		name     string
+		source   sourceChecksum
+		existing []*drive.File
+		expected string
+		streamed bool
+	}
+
+	existing := []*drive.File{
+		{Id: "other", Size: 10, Md5Checksum: "aaa", Sha256Checksum: "111"},
+		{Id: "same", Size: 20, Md5Checksum: "bbb", Sha256Checksum: "222"},
+	}
+
+	tests := []tc{
+		{
+			name:     "etag md5",
+			source:   sourceChecksum{md5: "BBB"},
+			existing: existing,
+			expected: "same",
+		},
+		{
+			name:     "known sha256 differs",
+			source:   sourceChecksum{sha256: "333", size: 20},
+			existing: existing,
+			expected: "",
+		},
+		{
+			name:     "multipart object is streamed",
+			source:   sourceChecksum{size: 20},
+			existing: existing,
+			expected: "same",
+			streamed: true,
+		},
+		{
+			name:     "multipart object of other size is not streamed",
+			source:   sourceChecksum{size: 30},
+			existing: existing,
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			streamed := false
+			test.source.streamSha256 = func(ctx context.Context) (string, error) {
+				streamed = true
+				return "222", nil
+			}
+			got, err := findIdentical(context.Background(), test.source, test.existing)
+			if err != nil {
+				t.Fatalf("findIdentical() error = %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("findIdentical() = %q, want %q", got, test.expected)
+			}
+			if streamed != test.streamed {
+				t.Errorf("streamed = %t, want %t", streamed, test.streamed)
+			}
+		})
+	}
+}
+
+var nameQuery = regexp.MustCompile(`name (=|contains) '([^']*)'`)
+
+// Drive folder answering name queries like Drive, "contains" matches the start of the name.
+// Counts list calls and records trashed files.
+type fakeFolder struct {
+	files   []*drive.File
+	lists   int
+	trashed []string
+}
+
+func (f *fakeFolder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.lists++
+		match := nameQuery.FindStringSubmatch(r.URL.Query().Get("q"))
+		if match == nil {
+			http.Error(w, "unexpected query "+r.URL.Query().Get("q"), http.StatusBadRequest)
+			return
+		}
+		found := []*drive.File{}
+		for _, file := range f.files {
+			if (match[1] == "=" && file.Name == match[2]) || (match[1] == "contains" && strings.HasPrefix(file.Name, match[2])) {
+				found = append(found, file)
+			}
+		}
+		json.NewEncoder(w).Encode(drive.FileList{Files: found})
+	case http.MethodPatch:
+		var update drive.File
+		json.NewDecoder(r.Body).Decode(&update)
+		if !update.Trashed {
+			http.Error(w, "expected trashed update", http.StatusBadRequest)
+			return
+		}
+		id := path.Base(r.URL.Path)
+		f.trashed = append(f.trashed, id)
+		json.NewEncoder(w).Encode(drive.File{Id: id, Trashed: true})
+	default:
+		http.Error(w, "unexpected method "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func fakeDrive(t *testing.T, folder *fakeFolder) *drive.Service {
+	server := httptest.NewServer(folder)
+	t.Cleanup(server.Close)
+	svc, err := drive.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestPlanUpload(t *testing.T) {
+	type tc struct {
+		name     string
+		policy   ConflictPolicy
+		files    []*drive.File
+		source   sourceChecksum
+		expected uploadPlan
+		lists    int
+		wantErr  bool
+	}
+
+	sameName := []*drive.File{
+		{Id: "old", Name: "a.png", Md5Checksum: "aaa", ModifiedTime: "2025-05-01T10:00:00.000Z"},
+		{Id: "newest", Name: "a.png", Md5Checksum: "bbb", ModifiedTime: "2025-05-03T10:00:00.000Z"},
+		{Id: "middle", Name: "a.png", Md5Checksum: "ccc", ModifiedTime: "2025-05-02T10:00:00.000Z"},
+	}
+	var allTaken []*drive.File
+	for i := 0; i <= maxSuffixCounter; i++ {
+		name := "a.png"
+		if i > 0 {
+			name = fmt.Sprintf("a (%d).png", i)
+		}
+		allTaken = append(allTaken, &drive.File{Id: name, Name: name})
+	}
+
+	tests := []tc{
+		{name: "create new doesn't list", policy: ConflictCreateNew, files: sameName, expected: uploadPlan{name: "a.png"}},
+		{name: "replace lists only after upload", policy: ConflictReplace, files: sameName, expected: uploadPlan{name: "a.png"}},
+		{name: "new revision of missing file", policy: ConflictNewRevision, expected: uploadPlan{name: "a.png"}, lists: 1},
+		{name: "new revision of newest file", policy: ConflictNewRevision, files: sameName, expected: uploadPlan{name: "a.png", updateId: "newest"}, lists: 1},
+		{
+			name:   "new revision with two newest files",
+			policy: ConflictNewRevision,
+			files: []*drive.File{
+				{Id: "one", Name: "a.png", ModifiedTime: "2025-05-03T10:00:00.000Z"},
+				{Id: "two", Name: "a.png", ModifiedTime: "2025-05-03T10:00:00Z"},
+			},
+			lists:   1,
+			wantErr: true,
+		},
+		{name: "skip identical", policy: ConflictSkipIdentical, files: sameName, source: sourceChecksum{md5: "ccc"}, expected: uploadPlan{name: "a.png", identicalId: "middle"}, lists: 1},
+		{name: "upload different content", policy: ConflictSkipIdentical, files: sameName, source: sourceChecksum{md5: "ddd"}, expected: uploadPlan{name: "a.png"}, lists: 1},
+		{name: "free name keeps it", policy: ConflictSuffixCounter, files: []*drive.File{{Id: "b", Name: "b.png"}}, expected: uploadPlan{name: "a.png"}, lists: 1},
+		{
+			name:   "first free suffix from one listing",
+			policy: ConflictSuffixCounter,
+			files: []*drive.File{
+				{Id: "1", Name: "a.png"},
+				{Id: "2", Name: "a (1).png"},
+				{Id: "3", Name: "a (3).png"},
+				{Id: "4", Name: "ab (2).png"},
+			},
+			expected: uploadPlan{name: "a (2).png"},
+			lists:    2,
+		},
+		{name: "all suffixes taken", policy: ConflictSuffixCounter, files: allTaken, lists: 2, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			folder := &fakeFolder{files: test.files}
+			svc := fakeDrive(t, folder)
+
+			got, err := planUpload(context.Background(), svc, "dest", "a.png", test.policy, test.source)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("planUpload() error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && got != test.expected {
+				t.Errorf("planUpload() = %+v, want %+v", got, test.expected)
+			}
+			if folder.lists != test.lists {
+				t.Errorf("list calls = %d, want %d", folder.lists, test.lists)
+			}
+		})
+	}
+}
+
+func TestRemoveDuplicates(t *testing.T) {
+	folder := &fakeFolder{files: []*drive.File{
+		{Id: "dup1", Name: "a.png"},
+		{Id: "uploaded", Name: "a.png"},
+		{Id: "dup2", Name: "a.png"},
+		{Id: "other", Name: "b.png"},
+	}}
+	svc := fakeDrive(t, folder)
+
+	removed, err := removeDuplicates(context.Background(), svc, "dest", "a.png", "uploaded")
+	if err != nil {
+		t.Fatalf("removeDuplicates() error = %v", err)
+	}
+	expected := []string{"dup1", "dup2"}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("removeDuplicates() = %v, want %v", removed, expected)
+	}
+	if !reflect.DeepEqual(folder.trashed, expected) {
+		t.Errorf("trashed = %v, want %v", folder.trashed, expected)
+	}
+}
@@ -0,0 +1,59 @@
+package fileTransfer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target format of a Google Workspace file (Docs, Sheets, Slides, Drawings) exported to S3.
+// Unlike downloads, exports skip checksum verification, because Drive doesn't report checksums of the export.
+type ExportFormat string
+
+const (
+	ExportPdf  ExportFormat = "pdf"
+	ExportDocx ExportFormat = "docx"
+	ExportTxt  ExportFormat = "txt"
+	ExportCsv  ExportFormat = "csv"
+	ExportPng  ExportFormat = "png"
+)
+
+const workspaceMimePrefix = "application/vnd.google-apps."
+
+var exportMimeTypes = map[ExportFormat]string{
+	ExportPdf:  "application/pdf",
+	ExportDocx: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	ExportTxt:  "text/plain",
+	ExportCsv:  "text/csv",
+	ExportPng:  "image/png",
+}
+
+// Native Google files have no binary content and must be exported
+func isWorkspaceFile(mimeType string) bool {
+	return strings.HasPrefix(mimeType, workspaceMimePrefix) &&
+		mimeType != workspaceMimePrefix+"folder" &&
+		mimeType != workspaceMimePrefix+"shortcut"
+}
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	f := ExportFormat(strings.ToLower(format))
+	if f == "" {
+		return f, nil
+	}
+	if _, ok := exportMimeTypes[f]; !ok {
+		return "", fmt.Errorf("Unknown export format: %s", format)
+	}
+	return f, nil
+}
+
+// Returns MIME type passed to Files.Export. Drive rejects combinations the source type does not support,
+// e.g. CSV exports only the first sheet of a spreadsheet and PNG only the first slide.
+func exportMimeType(fileId string, sourceMimeType string, format ExportFormat) (string, error) {
+	if format == "" {
+		return "", fmt.Errorf("File %s of type %s can be only exported, export format is required", fileId, sourceMimeType)
+	}
+	mimeType, ok := exportMimeTypes[format]
+	if !ok {
+		return "", fmt.Errorf("Unknown export format: %s", format)
+	}
+	return mimeType, nil
+}
@@ -0,0 +1,90 @@
+package fileTransfer
+
+import "testing"
+
+func TestIsWorkspaceFile(t *testing.T) {
+	type tc struct {
+		name     string
+		mimeType string
+		expected bool
+	}
+
+	tests := []tc{
+		{name: "document", mimeType: "application/vnd.google-apps.document", expected: true},
+		{name: "spreadsheet", mimeType: "application/vnd.google-apps.spreadsheet", expected: true},
+		{name: "presentation", mimeType: "application/vnd.google-apps.presentation", expected: true},
+		{name: "folder", mimeType: "application/vnd.google-apps.folder", expected: false},
+		{name: "shortcut", mimeType: "application/vnd.google-apps.shortcut", expected: false},
+		{name: "pdf", mimeType: "application/pdf", expected: false},
+		{name: "video", mimeType: "video/mp4", expected: false},
+		{name: "empty", mimeType: "", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := isWorkspaceFile(test.mimeType)
+			if got != test.expected {
+				t.Errorf("isWorkspaceFile(%q) = %t, want %t", test.mimeType, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestExportMimeType(t *testing.T) {
+	type tc struct {
+		name     string
+		format   ExportFormat
+		expected string
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "pdf", format: ExportPdf, expected: "application/pdf"},
+		{name: "docx", format: ExportDocx, expected: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "txt", format: ExportTxt, expected: "text/plain"},
+		{name: "csv", format: ExportCsv, expected: "text/csv"},
+		{name: "png", format: ExportPng, expected: "image/png"},
+		{name: "missing format", format: "", wantErr: true},
+		{name: "unknown format", format: "odt", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := exportMimeType("file", "application/vnd.google-apps.document", test.format)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("exportMimeType(%q) error = %v, wantErr %t", test.format, err, test.wantErr)
+			}
+			if got != test.expected {
+				t.Errorf("exportMimeType(%q) = %q, want %q", test.format, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	type tc struct {
+		name     string
+		format   string
+		expected ExportFormat
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{name: "empty", format: "", expected: ""},
+		{name: "lower case", format: "pdf", expected: ExportPdf},
+		{name: "upper case", format: "DOCX", expected: ExportDocx},
+		{name: "unknown", format: "odt", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseExportFormat(test.format)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseExportFormat(%q) error = %v, wantErr %t", test.format, err, test.wantErr)
+			}
+			if got != test.expected {
+				t.Errorf("ParseExportFormat(%q) = %q, want %q", test.format, got, test.expected)
+			}
+		})
+	}
+}
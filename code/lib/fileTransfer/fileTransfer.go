@@ -1,79 +1,276 @@
 package fileTransfer
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"io"
+	"net/http"
 
-	"google.golang.org/api/drive/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 )
 
+const (
+	DefaultPartSize    int64 = 16 * 1024 * 1024
+	DefaultConcurrency int   = 3
+)
+
+type S3ToDriveOptions struct {
+	// Empty means ConflictCreateNew
+	Conflict ConflictPolicy
+}
+
 // MimeType can be empty to be autodetected
 func S3ToDrive(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, s3Bucket string, s3Key string, folderId string, fileName string, mimeType string) error {
+	_, err := S3ToDriveWithOptions(ctx, s3c, driveSvc, s3Bucket, s3Key, folderId, fileName, mimeType, S3ToDriveOptions{})
+	return err
+}
+
+// MimeType can be empty to be autodetected
+// Uploaded content is verified against the S3 object, on mismatch the upload is discarded and *ErrChecksumMismatch returned.
+func S3ToDriveWithOptions(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, s3Bucket string, s3Key string, folderId string, fileName string, mimeType string, opts S3ToDriveOptions) (UploadResult, error) {
+	policy, err := ParseConflictPolicy(string(opts.Conflict))
+	if err != nil {
+		return UploadResult{}, err
+	}
+
 	s3File, err := s3c.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &s3Bucket,
-		Key:    &s3Key,
+		Bucket:       &s3Bucket,
+		Key:          &s3Key,
+		ChecksumMode: types.ChecksumModeEnabled,
 	})
 	if err != nil {
-		return errors.Join(fmt.Errorf("Error downloading file from s3=%s key=%s", s3Bucket, s3Key), err)
+		return UploadResult{}, errors.Join(fmt.Errorf("Error downloading file from s3=%s key=%s", s3Bucket, s3Key), err)
 	}
 	defer s3File.Body.Close()
 
-	_, err = driveSvc.Files.
-		Create(&drive.File{
-			Name:     fileName,
-			Parents:  []string{folderId},
-			MimeType: mimeType,
-		}).
-		Media(s3File.Body).
-		SupportsAllDrives(true).
-		Do()
+	plan, err := planUpload(ctx, driveSvc, folderId, fileName, policy, sourceChecksum{
+		md5:    etagMd5(s3File.ETag),
+		sha256: s3Sha256Hex(s3File.ChecksumSHA256),
+		size:   aws.ToInt64(s3File.ContentLength),
+		streamSha256: func(ctx context.Context) (string, error) {
+			return streamS3Sha256(ctx, s3c, s3Bucket, s3Key)
+		},
+	})
 	if err != nil {
-		return errors.Join(fmt.Errorf("Error uploading file to folder=%s file=%s", folderId, fileName), err)
+		return UploadResult{}, err
 	}
-	return nil
+	if plan.identicalId != "" {
+		return UploadResult{FileId: plan.identicalId, Name: fileName, Skipped: true}, nil
+	}
+
+	sum := newDigest()
+	media := io.TeeReader(s3File.Body, sum)
+	fields := googleapi.Field("id, size, md5Checksum, sha256Checksum, headRevisionId")
+	var driveFile *drive.File
+	if plan.updateId != "" {
+		driveFile, err = driveSvc.Files.
+			Update(plan.updateId, &drive.File{}).
+			Media(media).
+			SupportsAllDrives(true).
+			Fields(fields).
+			Do()
+	} else {
+		driveFile, err = driveSvc.Files.
+			Create(&drive.File{
+				Name:     plan.name,
+				Parents:  []string{folderId},
+				MimeType: mimeType,
+			}).
+			Media(media).
+			SupportsAllDrives(true).
+			Fields(fields).
+			Do()
+	}
+	if err != nil {
+		return UploadResult{}, errors.Join(fmt.Errorf("Error uploading file to folder=%s file=%s", folderId, plan.name), err)
+	}
+
+	location := fmt.Sprintf("s3=%s key=%s -> drive file=%s", s3Bucket, s3Key, driveFile.Id)
+	err = verifyS3ToDrive(location, s3File.ETag, s3File.ChecksumSHA256, aws.ToInt64(s3File.ContentLength), sum, driveFile)
+	if err != nil {
+		delErr := discardUpload(ctx, driveSvc, driveFile)
+		if delErr != nil {
+			return UploadResult{}, errors.Join(err, fmt.Errorf("Error deleting corrupted file %s: %w", driveFile.Id, delErr))
+		}
+		return UploadResult{}, err
+	}
+
+	result := UploadResult{FileId: driveFile.Id, Name: plan.name}
+	if policy == ConflictReplace {
+		result.Replaced, err = removeDuplicates(ctx, driveSvc, folderId, plan.name, driveFile.Id)
+	}
+	return result, err
+}
+
+// Compares what was read from S3 with the S3 object metadata, and with what Drive stored.
+func verifyS3ToDrive(location string, etag *string, s3Sha256 *string, s3Size int64, sum *digest, driveFile *drive.File) error {
+	return firstMismatch(
+		compareSize(location, s3Size, sum.size),
+		compareChecksum(location, "s3 etag md5", etagMd5(etag), sum.md5Hex()),
+		compareChecksum(location, "s3 sha256", s3Sha256Hex(s3Sha256), sum.sha256Hex()),
+		compareSize(location, sum.size, driveFile.Size),
+		compareChecksum(location, "drive md5", sum.md5Hex(), driveFile.Md5Checksum),
+		compareChecksum(location, "drive sha256", sum.sha256Hex(), driveFile.Sha256Checksum),
+	)
+}
+
+type DriveToS3Options struct {
+	// Size of a multipart upload part in bytes. Zero means DefaultPartSize.
+	// Memory used by the upload is bounded by PartSize * Concurrency.
+	PartSize int64
+	// Number of parts uploaded in parallel. Zero means DefaultConcurrency.
+	Concurrency int
+	// Format used for Google Workspace files (Docs, Sheets, Slides), which cannot be downloaded directly.
+	// Ignored for regular files. Exported content is not verified, Drive has no size or checksum of it.
+	ExportFormat ExportFormat
+}
+
+// Fills in defaults of zero values and checks the upload settings
+func (opts DriveToS3Options) withDefaults() (DriveToS3Options, error) {
+	if opts.PartSize == 0 {
+		opts.PartSize = DefaultPartSize
+	}
+	if opts.PartSize < manager.MinUploadPartSize {
+		return opts, fmt.Errorf("Part size %d is smaller than minimum %d", opts.PartSize, manager.MinUploadPartSize)
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Concurrency < 0 {
+		return opts, fmt.Errorf("Concurrency must be positive, got %d", opts.Concurrency)
+	}
+	return opts, nil
 }
 
 func DriveToS3(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, fileId string, s3Bucket string, s3Key string) error {
-	resp, err := driveSvc.Files.Get(fileId).Download()
+	return DriveToS3WithOptions(ctx, s3c, driveSvc, fileId, s3Bucket, s3Key, DriveToS3Options{})
+}
+
+// Streams the Drive download directly into S3 multipart upload, without touching the disk.
+// Incomplete multipart uploads are aborted on failure.
+func DriveToS3WithOptions(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, fileId string, s3Bucket string, s3Key string, opts DriveToS3Options) error {
+	opts, err := opts.withDefaults()
 	if err != nil {
-		return errors.Join(fmt.Errorf("Unable to download file: %s", fileId), err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	tmpFile, err := os.CreateTemp("", "gdrive-")
+	meta, err := driveSvc.Files.Get(fileId).
+		Context(ctx).
+		SupportsAllDrives(true).
+		Fields("id, name, mimeType, size, md5Checksum").
+		Do()
 	if err != nil {
-		return errors.Join(errors.New("Error creating temporary file"), err)
+		return errors.Join(fmt.Errorf("Unable to read file metadata: %s", fileId), err)
+	}
+
+	var contentType *string
+	exported := isWorkspaceFile(meta.MimeType)
+	var resp *http.Response
+	if exported {
+		exportMime, err := exportMimeType(fileId, meta.MimeType, opts.ExportFormat)
+		if err != nil {
+			return err
+		}
+		contentType = &exportMime
+		// Export output is limited by Drive to 10 MB
+		resp, err = driveSvc.Files.Export(fileId, exportMime).Context(ctx).Download()
+		if err != nil {
+			return errors.Join(fmt.Errorf("Unable to export file: %s as %s", fileId, exportMime), err)
+		}
+	} else {
+		resp, err = driveSvc.Files.Get(fileId).Context(ctx).Download()
+		if err != nil {
+			return errors.Join(fmt.Errorf("Unable to download file: %s", fileId), err)
+		}
 	}
-	defer os.Remove(tmpFile.Name()) // Clean up the temporary file
-	defer tmpFile.Close()
+	defer resp.Body.Close()
+
+	uploader := manager.NewUploader(s3c, func(u *manager.Uploader) {
+		u.PartSize = opts.PartSize
+		u.Concurrency = opts.Concurrency
+		u.LeavePartsOnError = false
+	})
 
-	_, err = io.Copy(tmpFile, resp.Body)
+	sum := newDigest()
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      &s3Bucket,
+		Key:         &s3Key,
+		Body:        io.TeeReader(resp.Body, sum),
+		ContentType: contentType,
+	})
 	if err != nil {
-		return errors.Join(fmt.Errorf("Error copying Google Drive content to temporary file: ", tmpFile.Name()), err)
+		var multiErr manager.MultiUploadFailure
+		if errors.As(err, &multiErr) {
+			err = errors.Join(fmt.Errorf("Multipart upload %s aborted", multiErr.UploadID()), err)
+		}
+		return errors.Join(fmt.Errorf("Error S3 upload: bucket=%s key=%s file=%s", s3Bucket, s3Key, fileId), err)
+	}
+
+	if exported {
+		// Drive has no size or checksum of exported content, it is stored unverified
+		return nil
 	}
 
-	// Rewind to start of FS stream
-	_, err = tmpFile.Seek(0, io.SeekStart)
+	// A truncated download must not be left in S3 to be processed further
+	location := fmt.Sprintf("drive file=%s -> s3=%s key=%s", fileId, s3Bucket, s3Key)
+	err = firstMismatch(
+		compareSize(location, meta.Size, sum.size),
+		compareChecksum(location, "drive md5", meta.Md5Checksum, sum.md5Hex()),
+	)
 	if err != nil {
-		return errors.Join(errors.New("Error file stream rewind"), err)
+		return deleteCorrupted(ctx, s3c, s3Bucket, s3Key, err)
 	}
 
-	_, err = s3c.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: &s3Bucket,
-			Key:    &s3Key,
-			Body:   tmpFile,
-		})
+	return nil
+}
+
+// Uploads data in a single part. S3 checks it against the sent SHA256, the returned ETag is compared with its MD5.
+// On mismatch the object is deleted and *ErrChecksumMismatch returned.
+func BytesToS3(ctx context.Context, s3c *s3.Client, data []byte, s3Bucket string, s3Key string, contentType string) error {
+	sum := newDigest()
+	sum.Write(data)
+	resp, err := s3c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:         &s3Bucket,
+		Key:            &s3Key,
+		Body:           bytes.NewReader(data),
+		ContentType:    &contentType,
+		ContentLength:  aws.Int64(int64(len(data))),
+		ChecksumSHA256: aws.String(sum.sha256Base64()),
+	})
 	if err != nil {
-		return errors.Join(fmt.Errorf("Error S3 upload: bucket=%s key=%s file=%s", s3Bucket, s3Key, fileId), err)
+		return errors.Join(fmt.Errorf("Error S3 upload: bucket=%s key=%s", s3Bucket, s3Key), err)
 	}
 
+	location := fmt.Sprintf("s3=%s key=%s", s3Bucket, s3Key)
+	err = firstMismatch(
+		compareChecksum(location, "s3 etag md5", sum.md5Hex(), etagMd5(resp.ETag)),
+		compareChecksum(location, "s3 sha256", sum.sha256Hex(), s3Sha256Hex(resp.ChecksumSHA256)),
+	)
+	if err != nil {
+		return deleteCorrupted(ctx, s3c, s3Bucket, s3Key, err)
+	}
 	return nil
 }
 
+// A corrupted object must not be left in S3 to be processed further
+func deleteCorrupted(ctx context.Context, s3c *s3.Client, s3Bucket string, s3Key string, err error) error {
+	_, delErr := s3c.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &s3Bucket,
+		Key:    &s3Key,
+	})
+	if delErr != nil {
+		return errors.Join(err, fmt.Errorf("Error deleting corrupted object s3=%s key=%s: %w", s3Bucket, s3Key, delErr))
+	}
+	return err
+}
+
 func S3ToLocal(ctx context.Context, s3c *s3.Client, s3Bucket string, s3Key string, writer io.Writer) error {
 	s3File, err := s3c.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: &s3Bucket,
@@ -0,0 +1,122 @@
+package fileTransfer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestDriveToS3OptionsWithDefaults(t *testing.T) {
+	type tc struct {
+		name        string
+		opts        DriveToS3Options
+		partSize    int64
+		concurrency int
+		wantErr     bool
+	}
+
+	mb := int64(1024 * 1024)
+	tests := []tc{
+		{name: "defaults", opts: DriveToS3Options{}, partSize: DefaultPartSize, concurrency: DefaultConcurrency},
+		{name: "custom", opts: DriveToS3Options{PartSize: 64 * mb, Concurrency: 8}, partSize: 64 * mb, concurrency: 8},
+		{name: "minimum part size", opts: DriveToS3Options{PartSize: 5 * mb}, partSize: 5 * mb, concurrency: DefaultConcurrency},
+		{name: "part size below minimum", opts: DriveToS3Options{PartSize: 5*mb - 1}, wantErr: true},
+		{name: "negative part size", opts: DriveToS3Options{PartSize: -1}, wantErr: true},
+		{name: "negative concurrency", opts: DriveToS3Options{Concurrency: -1}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.opts.withDefaults()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("withDefaults() error = %v, wantErr %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got.PartSize != test.partSize || got.Concurrency != test.concurrency {
+				t.Errorf("withDefaults() = part size %d concurrency %d, want %d %d", got.PartSize, got.Concurrency, test.partSize, test.concurrency)
+			}
+		})
+	}
+}
+
+func TestBytesToS3(t *testing.T) {
+	type tc struct {
+		name     string
+		etag     string
+		status   int
+		deleted  bool
+		mismatch bool
+		wantErr  bool
+	}
+
+	data := []byte("processed image")
+	sum := md5.Sum(data)
+	md5Hex := hex.EncodeToString(sum[:])
+
+	tests := []tc{
+		{name: "matching etag", etag: `"` + md5Hex + `"`},
+		{name: "multipart etag is not comparable", etag: `"0123-2"`},
+		{name: "different etag", etag: `"00000000000000000000000000000000"`, deleted: true, mismatch: true, wantErr: true},
+		{name: "rejected by s3", status: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deleted := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodPut:
+					if r.Header.Get("x-amz-checksum-sha256") == "" {
+						t.Error("PutObject without x-amz-checksum-sha256")
+					}
+					body, _ := io.ReadAll(r.Body)
+					if string(body) != string(data) {
+						t.Errorf("uploaded %q, want %q", body, data)
+					}
+					if test.status != 0 {
+						w.Header().Set("Content-Type", "application/xml")
+						w.WriteHeader(test.status)
+						io.WriteString(w, `<Error><Code>BadDigest</Code><Message>The SHA256 you specified did not match the calculated checksum.</Message></Error>`)
+						return
+					}
+					w.Header().Set("ETag", test.etag)
+				case http.MethodDelete:
+					deleted = true
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected method %s", r.Method)
+				}
+			}))
+			defer server.Close()
+			s3c := s3.New(s3.Options{
+				Region:       "us-east-1",
+				BaseEndpoint: aws.String(server.URL),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+				HTTPClient:   server.Client(),
+			})
+
+			err := BytesToS3(context.Background(), s3c, data, "bucket", "key", "image/jpeg")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("BytesToS3() error = %v, want error %v", err, test.wantErr)
+			}
+			var mismatch *ErrChecksumMismatch
+			if errors.As(err, &mismatch) != test.mismatch {
+				t.Errorf("BytesToS3() error = %v, want mismatch %v", err, test.mismatch)
+			}
+			if deleted != test.deleted {
+				t.Errorf("deleted = %v, want %v", deleted, test.deleted)
+			}
+		})
+	}
+}
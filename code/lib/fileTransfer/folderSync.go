@@ -0,0 +1,314 @@
+package fileTransfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/drive/v3"
+
+	"lambdalib/driveList"
+)
+
+const driveFolderMime = "application/vnd.google-apps.folder"
+
+type SyncOptions struct {
+	// Glob patterns (path.Match syntax) of relative paths to transfer. Empty means everything.
+	// Pattern without "/" is matched against the file name only.
+	Include []string
+	// Glob patterns of relative paths to leave out, evaluated after Include
+	Exclude []string
+	// Used for every uploaded file in S3PrefixToDriveFolder
+	Conflict ConflictPolicy
+	// Options of every download in DriveFolderToS3Prefix, including ExportFormat of Google Workspace files
+	Download DriveToS3Options
+	// Most files DriveFolderToS3Prefix copies in one call, zero means no limit.
+	// Keep it low enough for the Lambda timeout and call again with the returned continuation token.
+	Limit int
+	// Continuation token returned by the previous DriveFolderToS3Prefix call, empty starts from the beginning
+	Continue string
+}
+
+// One transferred file
+type ManifestItem struct {
+	// Path relative to the synced prefix/folder
+	Path     string   `json:"path"`
+	S3Key    string   `json:"s3Key"`
+	DriveId  string   `json:"driveId,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Skipped  bool     `json:"skipped,omitempty"`
+	Replaced []string `json:"replaced,omitempty"`
+	// Drive ID was added to the name, because an older file (or export) maps to the same S3 key
+	Renamed bool `json:"renamed,omitempty"`
+}
+
+func matchAny(patterns []string, relPath string) (bool, error) {
+	for _, pattern := range patterns {
+		target := relPath
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(relPath)
+		}
+		ok, err := path.Match(pattern, target)
+		if err != nil {
+			return false, fmt.Errorf("Invalid glob pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (o SyncOptions) selected(relPath string) (bool, error) {
+	if len(o.Include) > 0 {
+		ok, err := matchAny(o.Include, relPath)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	excluded, err := matchAny(o.Exclude, relPath)
+	return !excluded, err
+}
+
+// Validates patterns upfront, so a typo fails before anything is transferred
+func (o SyncOptions) validate() error {
+	for _, pattern := range append(append([]string{}, o.Include...), o.Exclude...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("Invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func prefixDir(prefix string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+	return prefix + "/"
+}
+
+// Mirrors all objects under s3Prefix into folderId. Key segments after the prefix become Drive subfolders,
+// existing subfolders are reused.
+func S3PrefixToDriveFolder(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, s3Bucket string, s3Prefix string, folderId string, opts SyncOptions) ([]ManifestItem, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+	s3Prefix = prefixDir(s3Prefix)
+
+	// Relative folder path -> Drive folder ID
+	folders := map[string]string{"": folderId}
+	manifest := []ManifestItem{}
+
+	paginator := s3.NewListObjectsV2Paginator(s3c, &s3.ListObjectsV2Input{
+		Bucket: &s3Bucket,
+		Prefix: &s3Prefix,
+	})
+	for paginator.HasMorePages() {
+		list, err := paginator.NextPage(ctx)
+		if err != nil {
+			return manifest, errors.Join(fmt.Errorf("Error listing bucket s3=%s prefix=%s", s3Bucket, s3Prefix), err)
+		}
+		for _, object := range list.Contents {
+			relPath := strings.TrimPrefix(*object.Key, s3Prefix)
+			// Folder markers created by the console
+			if relPath == "" || strings.HasSuffix(relPath, "/") {
+				continue
+			}
+			ok, err := opts.selected(relPath)
+			if err != nil {
+				return manifest, err
+			}
+			if !ok {
+				continue
+			}
+
+			parentId, err := ensureDriveFolder(ctx, driveSvc, folders, path.Dir(relPath))
+			if err != nil {
+				return manifest, err
+			}
+			result, err := S3ToDriveWithOptions(ctx, s3c, driveSvc, s3Bucket, *object.Key, parentId, path.Base(relPath), "", S3ToDriveOptions{
+				Conflict: opts.Conflict,
+			})
+			if err != nil {
+				return manifest, err
+			}
+			manifest = append(manifest, ManifestItem{
+				Path:     relPath,
+				S3Key:    *object.Key,
+				DriveId:  result.FileId,
+				Size:     *object.Size,
+				Skipped:  result.Skipped,
+				Replaced: result.Replaced,
+			})
+		}
+	}
+	return manifest, nil
+}
+
+// Returns ID of the Drive folder for relative path, creating missing folders on the way
+func ensureDriveFolder(ctx context.Context, driveSvc *drive.Service, folders map[string]string, relDir string) (string, error) {
+	if relDir == "." {
+		relDir = ""
+	}
+	if id, ok := folders[relDir]; ok {
+		return id, nil
+	}
+
+	parentId, err := ensureDriveFolder(ctx, driveSvc, folders, path.Dir(relDir))
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(relDir)
+
+	existing, err := driveList.First(ctx, driveSvc, driveList.Query{
+		Q:      driveList.InFolder(parentId, fmt.Sprintf("name = '%s' and mimeType = '%s'", driveList.EscapeQuery(name), driveFolderMime)),
+		Fields: "id",
+	})
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("Error looking for folder %s in %s", name, parentId), err)
+	}
+
+	if existing != nil {
+		folders[relDir] = existing.Id
+		return existing.Id, nil
+	}
+
+	created, err := driveSvc.Files.Create(&drive.File{
+		Name:     name,
+		MimeType: driveFolderMime,
+		Parents:  []string{parentId},
+	}).Context(ctx).SupportsAllDrives(true).Fields("id").Do()
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("Error creating folder %s in %s", name, parentId), err)
+	}
+	folders[relDir] = created.Id
+	return created.Id, nil
+}
+
+// Mirrors folderId with all its subfolders under s3Prefix. Subfolder names become key segments.
+// Google Workspace files are exported when opts.Download.ExportFormat is set, otherwise they are left out.
+// Files of one folder go in order of name and creation, a later file whose key is taken gets its Drive ID
+// as suffix, e.g. "photo (1AbC).jpg", so nothing is overwritten.
+// With opts.Limit the call stops after that many files and returns a continuation token for the next call,
+// empty when everything was copied. The folder should not change between the calls, files are counted by position.
+func DriveFolderToS3Prefix(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, folderId string, s3Bucket string, s3Prefix string, opts SyncOptions) ([]ManifestItem, string, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, "", err
+	}
+	w := &folderToS3{
+		s3c:      s3c,
+		driveSvc: driveSvc,
+		s3Bucket: s3Bucket,
+		s3Prefix: prefixDir(s3Prefix),
+		opts:     opts,
+		manifest: []ManifestItem{},
+		keys:     map[string]bool{},
+	}
+	if opts.Continue != "" {
+		w.start, err = strconv.Atoi(opts.Continue)
+		if err != nil || w.start < 0 {
+			return nil, "", fmt.Errorf("Invalid continuation token: %s", opts.Continue)
+		}
+	}
+
+	err = w.folder(ctx, folderId, "")
+	if errors.Is(err, errSyncLimit) {
+		return w.manifest, strconv.Itoa(w.position), nil
+	}
+	return w.manifest, "", err
+}
+
+// Ends the walk of the folder tree once opts.Limit files were copied
+var errSyncLimit = errors.New("sync limit reached")
+
+// State of one DriveFolderToS3Prefix call
+type folderToS3 struct {
+	s3c      *s3.Client
+	driveSvc *drive.Service
+	s3Bucket string
+	s3Prefix string
+	opts     SyncOptions
+	manifest []ManifestItem
+	// Keys of all files met so far, including ones copied by previous calls
+	keys map[string]bool
+	// Files before start were copied by previous calls
+	start    int
+	position int
+}
+
+func (w *folderToS3) folder(ctx context.Context, folderId string, relDir string) error {
+	children, err := driveList.All(ctx, w.driveSvc, driveList.Query{
+		Q:       driveList.InFolder(folderId, ""),
+		Fields:  "id, name, mimeType, size",
+		OrderBy: "name, createdTime",
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("Error listing folder %s", folderId), err)
+	}
+
+	for _, child := range children {
+		relPath := path.Join(relDir, child.Name)
+		if child.MimeType == driveFolderMime {
+			err = w.folder(ctx, child.Id, relPath)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isWorkspaceFile(child.MimeType) {
+			if w.opts.Download.ExportFormat == "" {
+				continue
+			}
+			relPath += "." + string(w.opts.Download.ExportFormat)
+		} else if strings.HasPrefix(child.MimeType, workspaceMimePrefix) {
+			// Shortcuts and other items without content
+			continue
+		}
+
+		ok, err := w.opts.selected(relPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		renamed := w.keys[w.s3Prefix+relPath]
+		if renamed {
+			ext := path.Ext(relPath)
+			relPath = fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(relPath, ext), child.Id, ext)
+		}
+		s3Key := w.s3Prefix + relPath
+		w.keys[s3Key] = true
+
+		if w.position < w.start {
+			w.position++
+			continue
+		}
+		if w.opts.Limit > 0 && w.position >= w.start+w.opts.Limit {
+			return errSyncLimit
+		}
+
+		err = DriveToS3WithOptions(ctx, w.s3c, w.driveSvc, child.Id, w.s3Bucket, s3Key, w.opts.Download)
+		if err != nil {
+			return err
+		}
+		w.manifest = append(w.manifest, ManifestItem{
+			Path:    relPath,
+			S3Key:   s3Key,
+			DriveId: child.Id,
+			Size:    child.Size,
+			Renamed: renamed,
+		})
+		w.position++
+	}
+	return nil
+}
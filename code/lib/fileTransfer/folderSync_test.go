@@ -0,0 +1,224 @@
+package fileTransfer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+func TestSyncOptionsSelected(t *testing.T) {
+	type tc struct {
+		name     string
+		opts     SyncOptions
+		path     string
+		expected bool
+	}
+
+	tests := []tc{
+		{
+			name:     "no filters",
+			path:     "a/b/video.mp4",
+			expected: true,
+		},
+		{
+			name:     "include by file name",
+			opts:     SyncOptions{Include: []string{"*.srt"}},
+			path:     "en/subs.srt",
+			expected: true,
+		},
+		{
+			name:     "not included",
+			opts:     SyncOptions{Include: []string{"*.srt"}},
+			path:     "en/video.mp4",
+			expected: false,
+		},
+		{
+			name:     "include by path",
+			opts:     SyncOptions{Include: []string{"en/*"}},
+			path:     "en/subs.srt",
+			expected: true,
+		},
+		{
+			name:     "include by path in other folder",
+			opts:     SyncOptions{Include: []string{"en/*"}},
+			path:     "ru/subs.srt",
+			expected: false,
+		},
+		{
+			name:     "excluded after include",
+			opts:     SyncOptions{Include: []string{"*.srt"}, Exclude: []string{"draft-*"}},
+			path:     "en/draft-subs.srt",
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.opts.selected(test.path)
+			if err != nil {
+				t.Fatalf("selected() error = %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("selected(%q) = %t, want %t", test.path, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSyncOptionsValidate(t *testing.T) {
+	err := SyncOptions{Include: []string{"*.srt"}, Exclude: []string{"[a-"}}.validate()
+	if err == nil {
+		t.Errorf("validate() expected error for malformed pattern")
+	}
+}
+
+var parentQuery = regexp.MustCompile(`'([^']*)' in parents`)
+
+// Drive with folder "root" holding two files named a.jpg and a subfolder with one file
+func treeServer(t *testing.T) *httptest.Server {
+	folders := map[string][]*drive.File{
+		"root": {
+			{Id: "older", Name: "a.jpg", MimeType: "image/jpeg"},
+			{Id: "newer", Name: "a.jpg", MimeType: "image/jpeg"},
+			{Id: "sub", Name: "sub", MimeType: driveFolderMime},
+		},
+		"sub": {
+			{Id: "text", Name: "b.txt", MimeType: "text/plain"},
+		},
+	}
+	content := map[string]string{"older": "older image", "newer": "newer image", "text": "text"}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/files" {
+			if got := r.URL.Query().Get("orderBy"); got != "name, createdTime" {
+				t.Errorf("orderBy = %q, want stable order", got)
+			}
+			match := parentQuery.FindStringSubmatch(r.URL.Query().Get("q"))
+			json.NewEncoder(w).Encode(drive.FileList{Files: folders[match[1]]})
+			return
+		}
+		id := path.Base(r.URL.Path)
+		if r.URL.Query().Get("alt") == "media" {
+			io.WriteString(w, content[id])
+			return
+		}
+		sum := md5.Sum([]byte(content[id]))
+		json.NewEncoder(w).Encode(drive.File{Id: id, Size: int64(len(content[id])), Md5Checksum: hex.EncodeToString(sum[:])})
+	}))
+}
+
+// Records keys of uploaded objects
+type keyRecorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (k *keyRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	io.Copy(io.Discard, r.Body)
+	k.keys = append(k.keys, strings.TrimPrefix(r.URL.Path, "/bucket/"))
+}
+
+func TestDriveFolderToS3Prefix(t *testing.T) {
+	type tc struct {
+		name    string
+		limit   int
+		cont    string
+		keys    []string
+		renamed []bool
+		next    string
+		wantErr bool
+	}
+
+	tests := []tc{
+		{
+			name:    "same name gets drive id",
+			keys:    []string{"out/a.jpg", "out/a (newer).jpg", "out/sub/b.txt"},
+			renamed: []bool{false, true, false},
+		},
+		{
+			name:    "first call with limit",
+			limit:   2,
+			keys:    []string{"out/a.jpg", "out/a (newer).jpg"},
+			renamed: []bool{false, true},
+			next:    "2",
+		},
+		{
+			name:    "continued call",
+			limit:   2,
+			cont:    "2",
+			keys:    []string{"out/sub/b.txt"},
+			renamed: []bool{false},
+		},
+		{
+			name:    "continued after the first file",
+			limit:   1,
+			cont:    "1",
+			keys:    []string{"out/a (newer).jpg"},
+			renamed: []bool{true},
+			next:    "2",
+		},
+		{name: "invalid token", cont: "x", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			driveServer := treeServer(t)
+			defer driveServer.Close()
+			svc, err := drive.NewService(ctx, option.WithEndpoint(driveServer.URL), option.WithHTTPClient(driveServer.Client()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := &keyRecorder{}
+			s3Server := httptest.NewServer(recorder)
+			defer s3Server.Close()
+			s3c := s3.New(s3.Options{
+				Region:       "us-east-1",
+				BaseEndpoint: aws.String(s3Server.URL),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+				HTTPClient:   s3Server.Client(),
+			})
+
+			manifest, next, err := DriveFolderToS3Prefix(ctx, s3c, svc, "root", "bucket", "out", SyncOptions{Limit: test.limit, Continue: test.cont})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("DriveFolderToS3Prefix() error = %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			var keys []string
+			var renamed []bool
+			for _, item := range manifest {
+				keys = append(keys, item.S3Key)
+				renamed = append(renamed, item.Renamed)
+			}
+			if !reflect.DeepEqual(keys, test.keys) || !reflect.DeepEqual(renamed, test.renamed) {
+				t.Errorf("manifest keys = %v renamed = %v, want %v %v", keys, renamed, test.keys, test.renamed)
+			}
+			if !reflect.DeepEqual(recorder.keys, test.keys) {
+				t.Errorf("uploaded = %v, want %v", recorder.keys, test.keys)
+			}
+			if next != test.next {
+				t.Errorf("continuation = %q, want %q", next, test.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package fileTransfer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const (
+	driveUploadBaseUrl = "https://www.googleapis.com/upload/drive/v3/files"
+	driveUploadUrl     = driveUploadBaseUrl + "?uploadType=resumable&supportsAllDrives=true"
+
+	// Drive requires chunks to be a multiple of 256 KiB (except the last one)
+	ResumableChunkAlign   int64 = 256 * 1024
+	DefaultResumableChunk int64 = 32 * ResumableChunkAlign
+)
+
+var errSessionExpired = errors.New("Resumable upload session expired")
+
+// SessionStore persists the resumable session URI, so a retried invocation can continue the upload.
+type SessionStore interface {
+	Load(ctx context.Context) (string, error)
+	Save(ctx context.Context, sessionUri string) error
+	Clear(ctx context.Context) error
+}
+
+// Stores the session URI as a small S3 object.
+type S3SessionStore struct {
+	Client *s3.Client
+	Bucket string
+	Key    string
+}
+
+func (st *S3SessionStore) Load(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	err := S3ToLocal(ctx, st.Client, st.Bucket, st.Key, buf)
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func (st *S3SessionStore) Save(ctx context.Context, sessionUri string) error {
+	_, err := st.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &st.Bucket,
+		Key:    &st.Key,
+		Body:   strings.NewReader(sessionUri),
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("Error saving upload session s3=%s key=%s", st.Bucket, st.Key), err)
+	}
+	return nil
+}
+
+func (st *S3SessionStore) Clear(ctx context.Context) error {
+	_, err := st.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &st.Bucket,
+		Key:    &st.Key,
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("Error deleting upload session s3=%s key=%s", st.Bucket, st.Key), err)
+	}
+	return nil
+}
+
+type ResumableOptions struct {
+	// Size of one uploaded chunk. Must be a multiple of ResumableChunkAlign. Zero means DefaultResumableChunk.
+	ChunkSize int64
+	// Called after every accepted chunk
+	Progress func(uploaded int64, total int64)
+	// Optional. When set, an interrupted upload continues from the last committed byte.
+	Session SessionStore
+	// Empty means ConflictCreateNew
+	Conflict ConflictPolicy
+}
+
+// Uploads S3 object to Drive using the resumable upload protocol.
+// httpc must be authorized for Drive (see clientInit.InitGHttpClient).
+// MimeType can be empty to be autodetected
+func S3ToDriveResumable(ctx context.Context, s3c *s3.Client, driveSvc *drive.Service, httpc *http.Client, s3Bucket string, s3Key string, folderId string, fileName string, mimeType string, opts ResumableOptions) (UploadResult, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultResumableChunk
+	}
+	if chunkSize < 0 || chunkSize%ResumableChunkAlign != 0 {
+		return UploadResult{}, fmt.Errorf("Chunk size %d must be a positive multiple of %d", chunkSize, ResumableChunkAlign)
+	}
+	policy, err := ParseConflictPolicy(string(opts.Conflict))
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	head, err := s3c.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &s3Bucket,
+		Key:          &s3Key,
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return UploadResult{}, errors.Join(fmt.Errorf("Error reading object s3=%s key=%s", s3Bucket, s3Key), err)
+	}
+	total := *head.ContentLength
+
+	sessionUri := ""
+	offset := int64(0)
+	if opts.Session != nil {
+		sessionUri, err = opts.Session.Load(ctx)
+		if err != nil {
+			return UploadResult{}, err
+		}
+	}
+
+	if sessionUri != "" {
+		var fileId string
+		offset, fileId, err = querySession(ctx, httpc, sessionUri, total)
+		if errors.Is(err, errSessionExpired) {
+			sessionUri = ""
+			offset = 0
+		} else if err != nil {
+			return UploadResult{}, err
+		} else if fileId != "" {
+			return finishResumable(ctx, driveSvc, opts.Session, policy, fileId, s3Bucket, s3Key, folderId, head)
+		}
+	}
+
+	if sessionUri == "" {
+		// Conflict is resolved only when a session starts, a resumed session keeps its target
+		plan, err := planUpload(ctx, driveSvc, folderId, fileName, policy, sourceChecksum{
+			md5:    etagMd5(head.ETag),
+			sha256: s3Sha256Hex(head.ChecksumSHA256),
+			size:   total,
+			streamSha256: func(ctx context.Context) (string, error) {
+				return streamS3Sha256(ctx, s3c, s3Bucket, s3Key)
+			},
+		})
+		if err != nil {
+			return UploadResult{}, err
+		}
+		if plan.identicalId != "" {
+			return UploadResult{FileId: plan.identicalId, Name: fileName, Skipped: true}, nil
+		}
+
+		sessionUri, err = startSession(ctx, httpc, folderId, plan, mimeType, total)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		if opts.Session != nil {
+			err = opts.Session.Save(ctx, sessionUri)
+			if err != nil {
+				return UploadResult{}, err
+			}
+		}
+	}
+
+	fileId, err := uploadChunks(ctx, s3c, httpc, sessionUri, s3Bucket, s3Key, offset, total, chunkSize, opts.Progress)
+	if err != nil {
+		err = errors.Join(fmt.Errorf("Error uploading file to folder=%s file=%s", folderId, fileName), err)
+		// Session URI grants upload without other credentials, keep it only when a retry can use it
+		if opts.Session != nil && !IsTransient(err) {
+			err = errors.Join(err, opts.Session.Clear(ctx))
+		}
+		return UploadResult{}, err
+	}
+	return finishResumable(ctx, driveSvc, opts.Session, policy, fileId, s3Bucket, s3Key, folderId, head)
+}
+
+// Upload may span several invocations, so the stream cannot be hashed.
+// The S3 object metadata is compared with checksums computed by Drive instead.
+func finishResumable(ctx context.Context, driveSvc *drive.Service, store SessionStore, policy ConflictPolicy, fileId string, s3Bucket string, s3Key string, folderId string, head *s3.HeadObjectOutput) (UploadResult, error) {
+	if store != nil {
+		err := store.Clear(ctx)
+		if err != nil {
+			return UploadResult{}, err
+		}
+	}
+
+	driveFile, err := driveSvc.Files.Get(fileId).
+		Context(ctx).
+		SupportsAllDrives(true).
+		Fields("id, name, size, md5Checksum, sha256Checksum, headRevisionId").
+		Do()
+	if err != nil {
+		return UploadResult{}, errors.Join(fmt.Errorf("Error reading metadata of file %s", fileId), err)
+	}
+
+	location := fmt.Sprintf("s3=%s key=%s -> drive file=%s", s3Bucket, s3Key, fileId)
+	err = firstMismatch(
+		compareSize(location, aws.ToInt64(head.ContentLength), driveFile.Size),
+		compareChecksum(location, "s3 etag md5", etagMd5(head.ETag), driveFile.Md5Checksum),
+		compareChecksum(location, "s3 sha256", s3Sha256Hex(head.ChecksumSHA256), driveFile.Sha256Checksum),
+	)
+	if err != nil {
+		delErr := discardUpload(ctx, driveSvc, driveFile)
+		if delErr != nil {
+			return UploadResult{}, errors.Join(err, fmt.Errorf("Error deleting corrupted file %s: %w", fileId, delErr))
+		}
+		return UploadResult{}, err
+	}
+
+	result := UploadResult{FileId: fileId, Name: driveFile.Name}
+	if policy == ConflictReplace {
+		result.Replaced, err = removeDuplicates(ctx, driveSvc, folderId, driveFile.Name, fileId)
+	}
+	return result, err
+}
+
+func startSession(ctx context.Context, httpc *http.Client, folderId string, plan uploadPlan, mimeType string, total int64) (string, error) {
+	method := http.MethodPost
+	url := driveUploadUrl
+	metadata := map[string]any{}
+	if plan.updateId != "" {
+		// New revision of an existing file
+		method = http.MethodPatch
+		url = fmt.Sprintf("%s/%s?uploadType=resumable&supportsAllDrives=true", driveUploadBaseUrl, plan.updateId)
+	} else {
+		metadata["name"] = plan.name
+		metadata["parents"] = []string{folderId}
+		if mimeType != "" {
+			metadata["mimeType"] = mimeType
+		}
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(total, 10))
+	if mimeType != "" {
+		req.Header.Set("X-Upload-Content-Type", mimeType)
+	}
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return "", transient(errors.Join(errors.New("Error starting resumable upload session"), err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError("Error starting resumable upload session", resp)
+	}
+	sessionUri := resp.Header.Get("Location")
+	if sessionUri == "" {
+		return "", errors.New("Resumable upload session response is missing Location header")
+	}
+	return sessionUri, nil
+}
+
+// Asks Drive how many bytes were committed. Returns file ID instead, when the upload is already complete.
+func querySession(ctx context.Context, httpc *http.Client, sessionUri string, total int64) (int64, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionUri, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.ContentLength = 0
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return 0, "", transient(errors.Join(errors.New("Error querying resumable upload session"), err))
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		fileId, err := decodeFileId(resp)
+		return total, fileId, err
+	case http.StatusPermanentRedirect:
+		offset, err := committedOffset(resp)
+		return offset, "", err
+	case http.StatusNotFound, http.StatusGone:
+		return 0, "", errSessionExpired
+	default:
+		return 0, "", responseError("Error querying resumable upload session", resp)
+	}
+}
+
+// Part of *s3.Client used to read the uploaded object
+type objectGetter interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+func uploadChunks(ctx context.Context, s3c objectGetter, httpc *http.Client, sessionUri string, s3Bucket string, s3Key string, offset int64, total int64, chunkSize int64, progress func(int64, int64)) (string, error) {
+	var body io.ReadCloser
+	defer func() {
+		if body != nil {
+			body.Close()
+		}
+	}()
+	// Position of the body stream. Differs from offset when Drive commits less than was sent.
+	bodyPos := int64(-1)
+	buf := make([]byte, chunkSize)
+
+	for {
+		if bodyPos != offset && offset < total {
+			if body != nil {
+				body.Close()
+			}
+			s3File, err := s3c.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: &s3Bucket,
+				Key:    &s3Key,
+				Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+			})
+			if err != nil {
+				return "", errors.Join(fmt.Errorf("Error downloading file from s3=%s key=%s offset=%d", s3Bucket, s3Key, offset), err)
+			}
+			body = s3File.Body
+			bodyPos = offset
+		}
+
+		n := int64(0)
+		if offset < total {
+			read, err := io.ReadFull(body, buf[:min(chunkSize, total-offset)])
+			if err != nil {
+				return "", transient(errors.Join(fmt.Errorf("Error reading file from s3=%s key=%s", s3Bucket, s3Key), err))
+			}
+			n = int64(read)
+			bodyPos += n
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionUri, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = n
+		if n > 0 {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, total))
+		} else {
+			// Empty file, or everything was committed and only the final response is missing
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		}
+
+		resp, err := httpc.Do(req)
+		if err != nil {
+			return "", transient(errors.Join(fmt.Errorf("Error uploading chunk at offset=%d", offset), err))
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated:
+			fileId, err := decodeFileId(resp)
+			resp.Body.Close()
+			if progress != nil {
+				progress(total, total)
+			}
+			return fileId, err
+		case http.StatusPermanentRedirect:
+			offset, err = committedOffset(resp)
+			resp.Body.Close()
+			if err != nil {
+				return "", err
+			}
+			if progress != nil {
+				progress(offset, total)
+			}
+		default:
+			err = responseError(fmt.Sprintf("Error uploading chunk at offset=%d", offset), resp)
+			resp.Body.Close()
+			return "", err
+		}
+	}
+}
+
+// Parses Range header of 308 response, e.g. "bytes=0-262143". Missing header means nothing was committed.
+func committedOffset(resp *http.Response) (int64, error) {
+	rangeHeader := resp.Header.Get("Range")
+	if rangeHeader == "" {
+		return 0, nil
+	}
+	_, last, found := strings.Cut(rangeHeader, "-")
+	if !found {
+		return 0, fmt.Errorf("Unexpected Range header: %s", rangeHeader)
+	}
+	lastByte, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, errors.Join(fmt.Errorf("Unexpected Range header: %s", rangeHeader), err)
+	}
+	return lastByte + 1, nil
+}
+
+func decodeFileId(resp *http.Response) (string, error) {
+	var file struct {
+		Id string `json:"id"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&file)
+	if err != nil {
+		return "", errors.Join(errors.New("Error decoding uploaded file metadata"), err)
+	}
+	return file.Id, nil
+}
+
+// Throttling and server errors are transient
+func responseError(msg string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err := fmt.Errorf("%s: status=%d body=%s", msg, resp.StatusCode, string(body))
+	if transientStatus(resp.StatusCode) {
+		return transient(err)
+	}
+	return err
+}
@@ -0,0 +1,265 @@
+package fileTransfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestCommittedOffset(t *testing.T) {
+	type tc struct {
+		name     string
+		header   string
+		expected int64
+		err      bool
+	}
+
+	tests := []tc{
+		{name: "missing header", header: "", expected: 0},
+		{name: "first chunk", header: "bytes=0-262143", expected: 262144},
+		{name: "single byte", header: "bytes=0-0", expected: 1},
+		{name: "no dash", header: "bytes=100", err: true},
+		{name: "not a number", header: "bytes=0-abc", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if test.header != "" {
+				resp.Header.Set("Range", test.header)
+			}
+			got, err := committedOffset(resp)
+			if (err != nil) != test.err {
+				t.Fatalf("committedOffset() error = %v, want error %v", err, test.err)
+			}
+			if got != test.expected {
+				t.Errorf("committedOffset() = %d, want %d", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestQuerySession(t *testing.T) {
+	type tc struct {
+		name      string
+		status    int
+		rangeHdr  string
+		body      string
+		offset    int64
+		fileId    string
+		expired   bool
+		transient bool
+		err       bool
+	}
+
+	tests := []tc{
+		{name: "complete", status: http.StatusOK, body: `{"id":"file1"}`, offset: 100, fileId: "file1"},
+		{name: "created", status: http.StatusCreated, body: `{"id":"file2"}`, offset: 100, fileId: "file2"},
+		{name: "partial", status: http.StatusPermanentRedirect, rangeHdr: "bytes=0-49", offset: 50},
+		{name: "nothing committed", status: http.StatusPermanentRedirect, offset: 0},
+		{name: "not found", status: http.StatusNotFound, expired: true, err: true},
+		{name: "gone", status: http.StatusGone, expired: true, err: true},
+		{name: "server error", status: http.StatusServiceUnavailable, transient: true, err: true},
+		{name: "bad request", status: http.StatusBadRequest, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPut {
+					t.Errorf("method = %s, want PUT", r.Method)
+				}
+				if got := r.Header.Get("Content-Range"); got != "bytes */100" {
+					t.Errorf("Content-Range = %q, want %q", got, "bytes */100")
+				}
+				if test.rangeHdr != "" {
+					w.Header().Set("Range", test.rangeHdr)
+				}
+				w.WriteHeader(test.status)
+				io.WriteString(w, test.body)
+			}))
+			defer server.Close()
+
+			offset, fileId, err := querySession(context.Background(), server.Client(), server.URL, 100)
+			if (err != nil) != test.err {
+				t.Fatalf("querySession() error = %v, want error %v", err, test.err)
+			}
+			if errors.Is(err, errSessionExpired) != test.expired {
+				t.Errorf("querySession() expired = %v, want %v", errors.Is(err, errSessionExpired), test.expired)
+			}
+			if IsTransient(err) != test.transient {
+				t.Errorf("querySession() transient = %v, want %v", IsTransient(err), test.transient)
+			}
+			if offset != test.offset || fileId != test.fileId {
+				t.Errorf("querySession() = (%d, %q), want (%d, %q)", offset, fileId, test.offset, test.fileId)
+			}
+		})
+	}
+}
+
+// Serves ranges of data like S3 GetObject
+type fakeGetter struct {
+	data   []byte
+	ranges []string
+}
+
+func (f *fakeGetter) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.ranges = append(f.ranges, *params.Range)
+	var start int
+	fmt.Sscanf(*params.Range, "bytes=%d-", &start)
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(f.data[start:]))}, nil
+}
+
+// Accepts chunks like Drive, committing at most commitLimit bytes of each one
+func resumableServer(t *testing.T, total int, commitLimit int, failStatus int, ranges *[]string, received *bytes.Buffer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentRange := r.Header.Get("Content-Range")
+		*ranges = append(*ranges, contentRange)
+		if failStatus != 0 {
+			w.WriteHeader(failStatus)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if len(body) > commitLimit {
+			body = body[:commitLimit]
+		}
+		received.Write(body)
+
+		if received.Len() == total {
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, `{"id":"uploaded"}`)
+			return
+		}
+		if received.Len() > 0 {
+			w.Header().Set("Range", "bytes=0-"+strconv.Itoa(received.Len()-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+	}))
+}
+
+func TestUploadChunks(t *testing.T) {
+	type tc struct {
+		name        string
+		size        int
+		offset      int64
+		chunkSize   int64
+		commitLimit int
+		failStatus  int
+		ranges      []string
+		reads       []string
+		transient   bool
+		err         bool
+	}
+
+	tests := []tc{
+		{
+			name:        "single chunk",
+			size:        10,
+			chunkSize:   16,
+			commitLimit: 16,
+			ranges:      []string{"bytes 0-9/10"},
+			reads:       []string{"bytes=0-"},
+		},
+		{
+			name:        "several chunks",
+			size:        10,
+			chunkSize:   4,
+			commitLimit: 4,
+			ranges:      []string{"bytes 0-3/10", "bytes 4-7/10", "bytes 8-9/10"},
+			reads:       []string{"bytes=0-"},
+		},
+		{
+			name:        "partial commit reopens the stream",
+			size:        8,
+			chunkSize:   4,
+			commitLimit: 2,
+			ranges:      []string{"bytes 0-3/8", "bytes 2-5/8", "bytes 4-7/8", "bytes 6-7/8"},
+			reads:       []string{"bytes=0-", "bytes=2-", "bytes=4-", "bytes=6-"},
+		},
+		{
+			name:        "resumed at offset",
+			size:        10,
+			offset:      4,
+			chunkSize:   8,
+			commitLimit: 8,
+			ranges:      []string{"bytes 4-9/10"},
+			reads:       []string{"bytes=4-"},
+		},
+		{
+			name:        "empty file",
+			size:        0,
+			chunkSize:   4,
+			commitLimit: 4,
+			ranges:      []string{"bytes */0"},
+		},
+		{
+			name:       "server error is transient",
+			size:       10,
+			chunkSize:  16,
+			failStatus: http.StatusInternalServerError,
+			ranges:     []string{"bytes 0-9/10"},
+			reads:      []string{"bytes=0-"},
+			transient:  true,
+			err:        true,
+		},
+		{
+			name:       "client error",
+			size:       10,
+			chunkSize:  16,
+			failStatus: http.StatusForbidden,
+			ranges:     []string{"bytes 0-9/10"},
+			reads:      []string{"bytes=0-"},
+			err:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(strings.Repeat("0123456789", 2)[:test.size])
+			received := &bytes.Buffer{}
+			received.Write(data[:test.offset])
+			var ranges []string
+			server := resumableServer(t, test.size, test.commitLimit, test.failStatus, &ranges, received)
+			defer server.Close()
+			getter := &fakeGetter{data: data}
+
+			var lastProgress int64
+			fileId, err := uploadChunks(context.Background(), getter, server.Client(), server.URL, "bucket", "key", test.offset, int64(test.size), test.chunkSize, func(uploaded int64, total int64) {
+				lastProgress = uploaded
+			})
+			if (err != nil) != test.err {
+				t.Fatalf("uploadChunks() error = %v, want error %v", err, test.err)
+			}
+			if IsTransient(err) != test.transient {
+				t.Errorf("uploadChunks() transient = %v, want %v", IsTransient(err), test.transient)
+			}
+			if strings.Join(ranges, ",") != strings.Join(test.ranges, ",") {
+				t.Errorf("Content-Range = %v, want %v", ranges, test.ranges)
+			}
+			if strings.Join(getter.ranges, ",") != strings.Join(test.reads, ",") {
+				t.Errorf("s3 ranges = %v, want %v", getter.ranges, test.reads)
+			}
+			if test.err {
+				return
+			}
+			if fileId != "uploaded" {
+				t.Errorf("uploadChunks() = %q, want %q", fileId, "uploaded")
+			}
+			if !bytes.Equal(received.Bytes(), data) {
+				t.Errorf("received %q, want %q", received.Bytes(), data)
+			}
+			if lastProgress != int64(test.size) {
+				t.Errorf("progress = %d, want %d", lastProgress, test.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package fileTransfer
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error which may go away on retry, e.g. network failure or Drive 5xx.
+// Lambdas return it unwrapped, so Step Functions can retry only errors named TransientError.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+func IsTransient(err error) bool {
+	var transient *TransientError
+	return errors.As(err, &transient)
+}
+
+func transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransientError{Err: err}
+}
+
+func transientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
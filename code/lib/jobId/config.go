@@ -0,0 +1,68 @@
+package jobId
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Generators for each route, configured by environment variables:
+//
+//   - JOB_ID_KIND "random" (default) or "ulid"
+//   - ID_LEN number of random characters, default 8
+//   - JOB_ID_PREFIXES JSON object mapping route to prefix, e.g. {"/unstable/v2/dmq/make": "dmq_"}
+type Generators struct {
+	routes map[string]*Generator
+	plain  *Generator
+}
+
+func FromEnv() (*Generators, error) {
+	config := Config{Kind: Kind(os.Getenv("JOB_ID_KIND")), Length: DefaultLength}
+
+	idLen := os.Getenv("ID_LEN")
+	if idLen != "" {
+		length, err := strconv.Atoi(idLen)
+		if err != nil {
+			return nil, fmt.Errorf("ID_LEN expected number, got %q", idLen)
+		}
+		config.Length = length
+	}
+
+	prefixes := map[string]string{}
+	raw := os.Getenv("JOB_ID_PREFIXES")
+	if raw != "" {
+		err := json.Unmarshal([]byte(raw), &prefixes)
+		if err != nil {
+			return nil, errors.Join(errors.New("JOB_ID_PREFIXES expected JSON object of strings"), err)
+		}
+	}
+	return NewGenerators(config, prefixes)
+}
+
+func NewGenerators(config Config, prefixes map[string]string) (*Generators, error) {
+	plain, err := NewGenerator(config)
+	if err != nil {
+		return nil, err
+	}
+	gens := &Generators{routes: map[string]*Generator{}, plain: plain}
+	for route, prefix := range prefixes {
+		routeConfig := config
+		routeConfig.Prefix = prefix
+		gens.routes[route], err = NewGenerator(routeConfig)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Invalid job ID config for route %s", route), err)
+		}
+	}
+	return gens, nil
+}
+
+// Generator of the route, routes without prefix get IDs without prefix
+func (g *Generators) For(route string) *Generator {
+	gen, ok := g.routes[route]
+	if !ok {
+		return g.plain
+	}
+	return gen
+}
@@ -0,0 +1,176 @@
+package jobId
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	// Random letters and digits from crypto/rand
+	KindRandom Kind = "random"
+	// ULID, 26 characters, sorts by creation time. Derived IDs are not ULIDs, see Derive.
+	KindUlid Kind = "ulid"
+
+	// Random characters when not configured
+	DefaultLength = 8
+
+	ulidLength = 26
+	// Derived IDs of the ULID kind are 128 bits of a hash in hex, so they can't be taken for ULIDs
+	derivedHexLength = 32
+	// Step Functions execution names are limited to 80 characters
+	maxLength = 80
+)
+
+const (
+	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// Crockford's base32 used by ULID
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// Prefix is a part of S3 keys and execution names, so only safe characters are allowed
+var prefixPattern = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+type Config struct {
+	Kind Kind
+	// Number of random characters, ignored for ULID
+	Length int
+	// Prepended to every ID, e.g. "vr_"
+	Prefix string
+}
+
+type Generator struct {
+	config  Config
+	now     func() time.Time
+	entropy io.Reader
+}
+
+func NewGenerator(config Config) (*Generator, error) {
+	if config.Kind == "" {
+		config.Kind = KindRandom
+	}
+	length := len(config.Prefix)
+	switch config.Kind {
+	case KindRandom:
+		if config.Length < 1 {
+			return nil, fmt.Errorf("Job ID length must be > 0, got %d", config.Length)
+		}
+		length += config.Length
+	case KindUlid:
+		length += derivedHexLength
+	default:
+		return nil, fmt.Errorf("Unknown job ID kind: %s", config.Kind)
+	}
+	if !prefixPattern.MatchString(config.Prefix) {
+		return nil, fmt.Errorf("Job ID prefix %q may contain only letters, digits, '_' and '-'", config.Prefix)
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("Job ID would have %d characters, at most %d are allowed", length, maxLength)
+	}
+	return &Generator{config: config, now: time.Now, entropy: rand.Reader}, nil
+}
+
+// New unguessable ID
+func (g *Generator) New() (string, error) {
+	switch g.config.Kind {
+	case KindUlid:
+		var id [16]byte
+		ms := uint64(g.now().UnixMilli())
+		binary.BigEndian.PutUint16(id[0:], uint16(ms>>32))
+		binary.BigEndian.PutUint32(id[2:], uint32(ms))
+		_, err := io.ReadFull(g.entropy, id[6:])
+		if err != nil {
+			return "", errors.Join(errors.New("Failed to read random bytes"), err)
+		}
+		return g.config.Prefix + encodeUlid(id), nil
+	default:
+		id, err := randomLetters(g.entropy, g.config.Length)
+		if err != nil {
+			return "", err
+		}
+		return g.config.Prefix + id, nil
+	}
+}
+
+// Deterministic ID for the key, the same key always gives the same ID.
+// A hash has no creation time, so the ULID generator returns 32 lowercase hex characters instead of a ULID.
+// Only IDs from New sort by time.
+func (g *Generator) Derive(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	switch g.config.Kind {
+	case KindUlid:
+		return g.config.Prefix + hex.EncodeToString(sum[:derivedHexLength/2])
+	default:
+		b := make([]byte, g.config.Length)
+		for i := range b {
+			// Expand the hash for lengths over its size
+			if i%len(sum) == 0 && i > 0 {
+				sum = sha256.Sum256(sum[:])
+			}
+			b[i] = letterBytes[int(sum[i%len(sum)])%len(letterBytes)]
+		}
+		return g.config.Prefix + string(b)
+	}
+}
+
+// Checks an ID received from a client before it is used in an execution ARN or S3 key
+func Valid(id string) bool {
+	return id != "" && len(id) <= maxLength && prefixPattern.MatchString(id)
+}
+
+// Spark names executions after the jobId, so the execution ARN is derived from the state machine ARN.
+// arn:aws:states:<region>:<account>:stateMachine:<name> -> arn:aws:states:<region>:<account>:execution:<name>:<jobId>
+func ExecutionArn(stateMachineArn string, jobId string) (string, error) {
+	parts := strings.Split(stateMachineArn, ":")
+	if len(parts) != 7 || parts[5] != "stateMachine" {
+		return "", fmt.Errorf("Invalid state machine ARN: %s", stateMachineArn)
+	}
+	parts[5] = "execution"
+	return strings.Join(append(parts, jobId), ":"), nil
+}
+
+func randomLetters(entropy io.Reader, length int) (string, error) {
+	// Bytes above the largest multiple of len(letterBytes) are dropped, so every letter is equally likely
+	limit := byte(256 / len(letterBytes) * len(letterBytes))
+	b := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(b) < length {
+		_, err := io.ReadFull(entropy, buf)
+		if err != nil {
+			return "", errors.Join(errors.New("Failed to read random bytes"), err)
+		}
+		for _, r := range buf {
+			if r < limit && len(b) < length {
+				b = append(b, letterBytes[int(r)%len(letterBytes)])
+			}
+		}
+	}
+	return string(b), nil
+}
+
+// 128 bits as 26 base32 characters, the first one carries only 3 bits
+func encodeUlid(id [16]byte) string {
+	out := make([]byte, ulidLength)
+	for i := range out {
+		// Bit offset in the 130 bit number with 2 leading zero bits
+		value := 0
+		for bit := i * 5; bit < i*5+5; bit++ {
+			value <<= 1
+			pos := bit - 2
+			if pos >= 0 && id[pos/8]&(0x80>>(pos%8)) != 0 {
+				value |= 1
+			}
+		}
+		out[i] = crockford[value]
+	}
+	return string(out)
+}
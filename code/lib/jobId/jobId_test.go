@@ -0,0 +1,203 @@
+package jobId
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeUlid(t *testing.T) {
+	var zero, full [16]byte
+	for i := range full {
+		full[i] = 0xFF
+	}
+	if got := encodeUlid(zero); got != "00000000000000000000000000" {
+		t.Errorf("zero = %s", got)
+	}
+	if got := encodeUlid(full); got != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("full = %s", got)
+	}
+}
+
+func TestUlidSortsByTime(t *testing.T) {
+	gen, err := NewGenerator(Config{Kind: KindUlid, Prefix: "vr_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.entropy = bytes.NewReader(bytes.Repeat([]byte{0xFF, 0x00}, 20))
+
+	start := time.Date(2025, 5, 28, 10, 0, 0, 0, time.UTC)
+	gen.now = func() time.Time { return start }
+	first, err := gen.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.now = func() time.Time { return start.Add(time.Millisecond) }
+	second, err := gen.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(first, "vr_") || len(first) != len("vr_")+ulidLength {
+		t.Errorf("unexpected id %s", first)
+	}
+	if first >= second {
+		t.Errorf("%s should sort before %s", first, second)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	gen, err := NewGenerator(Config{Length: 12, Prefix: "dmq_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := gen.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 16 || !strings.HasPrefix(id, "dmq_") {
+		t.Errorf("unexpected id %s", id)
+	}
+	for _, c := range id[4:] {
+		if !strings.ContainsRune(letterBytes, c) {
+			t.Errorf("unexpected character %c in %s", c, id)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	gen, err := NewGenerator(Config{Length: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen.Derive("a") != gen.Derive("a") {
+		t.Error("same key should give the same id")
+	}
+	if gen.Derive("a") == gen.Derive("b") {
+		t.Error("different keys should give different ids")
+	}
+	if len(gen.Derive("a")) != 40 {
+		t.Errorf("unexpected length of %s", gen.Derive("a"))
+	}
+}
+
+func TestDeriveUlidIsNotUlid(t *testing.T) {
+	gen, err := NewGenerator(Config{Kind: KindUlid, Prefix: "vr_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := gen.Derive("a")
+	if id != gen.Derive("a") {
+		t.Error("same key should give the same id")
+	}
+	if !strings.HasPrefix(id, "vr_") || len(id) != len("vr_")+derivedHexLength {
+		t.Errorf("unexpected id %s", id)
+	}
+	for _, c := range id[3:] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			t.Errorf("unexpected character %c in %s", c, id)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{Length: 0},
+		{Kind: "uuid", Length: 8},
+		{Length: 8, Prefix: "dmq/"},
+		{Kind: KindUlid, Prefix: strings.Repeat("x", 50)},
+	}
+	for _, config := range configs {
+		_, err := NewGenerator(config)
+		if err == nil {
+			t.Errorf("config %+v should be rejected", config)
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	gens, err := NewGenerators(Config{Length: 8}, map[string]string{"/dmq": "dmq_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := gens.For("/dmq").New()
+	if !strings.HasPrefix(id, "dmq_") {
+		t.Errorf("unexpected id %s", id)
+	}
+	id, _ = gens.For("/other").New()
+	if len(id) != 8 {
+		t.Errorf("unexpected id %s", id)
+	}
+}
+
+func TestValid(t *testing.T) {
+	cases := []struct {
+		id   string
+		want bool
+	}{
+		{"abcXYZ09", true},
+		{"vr_01JZ3K8Q7M4X5Y6Z7A8B9C0D1E", true},
+		{"a-b_c", true},
+		{"", false},
+		{"abc:def", false},
+		{"../abc", false},
+		{"abc def", false},
+		{strings.Repeat("x", 80), true},
+		{strings.Repeat("x", 81), false},
+	}
+	for _, c := range cases {
+		if got := Valid(c.id); got != c.want {
+			t.Errorf("Valid(%q) = %v, want %v", c.id, got, c.want)
+		}
+	}
+}
+
+func TestExecutionArn(t *testing.T) {
+	type tc struct {
+		name     string
+		sfnArn   string
+		jobId    string
+		expected string
+		wantErr  bool
+	}
+
+	tests := []tc{
+		{
+			name:     "state machine",
+			sfnArn:   "arn:aws:states:eu-central-1:123456789012:stateMachine:DMQs-Make-a1b2c3",
+			jobId:    "abc123",
+			expected: "arn:aws:states:eu-central-1:123456789012:execution:DMQs-Make-a1b2c3:abc123",
+		},
+		{
+			name:    "execution arn",
+			sfnArn:  "arn:aws:states:eu-central-1:123456789012:execution:DMQs-Make-a1b2c3:old",
+			jobId:   "abc123",
+			wantErr: true,
+		},
+		{
+			name:    "activity arn",
+			sfnArn:  "arn:aws:states:eu-central-1:123456789012:activity:Approve",
+			jobId:   "abc123",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			sfnArn:  "",
+			jobId:   "abc123",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ExecutionArn(test.sfnArn, test.jobId)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ExecutionArn() error = %v, wantErr %t", err, test.wantErr)
+			}
+			if got != test.expected {
+				t.Errorf("ExecutionArn() = %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Scopes of API keys passed by authorizer-psk in the request context
+package keyScope
+
+import (
+	"path"
+	"strings"
+)
+
+// Checks the state machine against the comma separated names or ARNs the API key is scoped to.
+// Authorizer sends "*" for keys without restriction, so empty scope means the request wasn't authorized.
+func AllowsStateMachine(scope string, sfnArn string) bool {
+	if strings.TrimSpace(scope) == "" {
+		return false
+	}
+	name := sfnArn[strings.LastIndex(sfnArn, ":")+1:]
+	for _, pattern := range strings.Split(scope, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == sfnArn {
+			return true
+		}
+		ok, err := path.Match(pattern, name)
+		if err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package keyScope
+
+import "testing"
+
+func TestAllowsStateMachine(t *testing.T) {
+	arn := "arn:aws:states:eu-central-1:123456789012:stateMachine:DMQs-Make-a1b2c3"
+	cases := []struct {
+		scope string
+		want  bool
+	}{
+		{"", false},
+		{"*", true},
+		{"DMQs-Make-*", true},
+		{"VideoRender-*, DMQs-Make-*", true},
+		{arn, true},
+		{"VideoRender-*", false},
+		{"DMQs-Make", false},
+	}
+	for _, c := range cases {
+		if got := AllowsStateMachine(c.scope, arn); got != c.want {
+			t.Errorf("AllowsStateMachine(%q) = %v, want %v", c.scope, got, c.want)
+		}
+	}
+}
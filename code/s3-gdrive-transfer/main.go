@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"path"
+
 	"go.uber.org/zap"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -19,6 +23,7 @@ var (
 	s3c      *s3.Client
 	log      *zap.SugaredLogger
 	driveSvc *drive.Service
+	httpc    *http.Client
 )
 
 type Event struct {
@@ -29,6 +34,27 @@ type Event struct {
 	MimeType      string    `json:"mimeType"`
 	S3Bucket      string    `json:"s3Bucket"`
 	S3Key         string    `json:"s3Key"`
+	Resumable     bool      `json:"resumable,omitempty"`
+	ChunkSizeMB   int64     `json:"chunkSizeMB,omitempty"`
+	// What to do when driveFileName already exists in driveFolderId, see fileTransfer.ConflictPolicy
+	Conflict      string    `json:"conflict,omitempty"`
+	// Format of exported Google Docs/Sheets/Slides: pdf, docx, txt, csv or png
+	ExportFormat  string    `json:"exportFormat,omitempty"`
+	// Key prefix mirrored by folder directions
+	S3Prefix      string    `json:"s3Prefix,omitempty"`
+	// Glob filters of paths relative to the synced prefix/folder, see fileTransfer.SyncOptions
+	Include       []string  `json:"include,omitempty"`
+	Exclude       []string  `json:"exclude,omitempty"`
+	// driveFolderToS3Prefix: most files copied by one invocation and the token returned by the previous one
+	Limit         int       `json:"limit,omitempty"`
+	Continue      string    `json:"continue,omitempty"`
+}
+
+type Response struct {
+	Direction string                      `json:"direction"`
+	Items     []fileTransfer.ManifestItem `json:"items"`
+	// Set when driveFolderToS3Prefix stopped at the limit, pass it as continue to the next invocation
+	Continue string `json:"continue,omitempty"`
 }
 
 func main() {
@@ -60,29 +86,128 @@ func init() {
 		log.Fatal(err)
 	}
 
-	driveSvc, _, err = clientInit.InitGDrive(ctx, clientInit.GInit{ConfigJson: &gcpConfig})
+	var gInit clientInit.GInit
+	driveSvc, gInit, err = clientInit.InitGDrive(ctx, clientInit.GInit{ConfigJson: &gcpConfig})
 	if err != nil {
 		log.Fatal("Error initializig Google service client: ", err)
 	}
+	httpc, _, err = clientInit.InitGHttpClient(ctx, gInit)
+	if err != nil {
+		log.Fatal("Error initializig Google http client: ", err)
+	}
+}
+
+func s3ToDriveResumable(ctx context.Context, event Event, policy fileTransfer.ConflictPolicy) (fileTransfer.UploadResult, error) {
+	// Session URI is kept next to the source object, so Lambda retries continue where the previous attempt ended
+	session := &fileTransfer.S3SessionStore{
+		Client: s3c,
+		Bucket: event.S3Bucket,
+		Key:    event.S3Key + ".gdrive-session",
+	}
+	return fileTransfer.S3ToDriveResumable(ctx, s3c, driveSvc, httpc, event.S3Bucket, event.S3Key, event.DriveFolderId, event.DriveFileName, event.MimeType, fileTransfer.ResumableOptions{
+		ChunkSize: event.ChunkSizeMB * 1024 * 1024,
+		Session:   session,
+		Conflict:  policy,
+		Progress: func(uploaded int64, total int64) {
+			log.Debugf("uploaded %d/%d bytes", uploaded, total)
+		},
+	})
+}
+
+// Transient errors are returned on top, the error name tells Step Functions to retry
+func HandleRequest(ctx context.Context, event Event) (Response, error) {
+	response, err := transfer(ctx, event)
+	if fileTransfer.IsTransient(err) {
+		return response, &fileTransfer.TransientError{Err: err}
+	}
+	return response, err
 }
 
-func HandleRequest(ctx context.Context, event Event) error {
+func transfer(ctx context.Context, event Event) (Response, error) {
 	log.Infof("direction=%s", event.Direction)
+	response := Response{Direction: event.Direction, Items: []fileTransfer.ManifestItem{}}
 	switch event.Direction {
 	case "s3ToDrive":
-		log.Debugf("uploading from bucket=%s key=%s to driveFolder=%s file=%s", event.S3Bucket, event.S3Key, event.DriveFolderId, event.DriveFileName)
-		err := fileTransfer.S3ToDrive(ctx, s3c, driveSvc, event.S3Bucket, event.S3Key, event.DriveFolderId, event.DriveFileName, event.MimeType)
+		policy, err := fileTransfer.ParseConflictPolicy(event.Conflict)
+		if err != nil {
+			return response, err
+		}
+		log.Debugf("uploading from bucket=%s key=%s to driveFolder=%s file=%s resumable=%t conflict=%s", event.S3Bucket, event.S3Key, event.DriveFolderId, event.DriveFileName, event.Resumable, policy)
+
+		var result fileTransfer.UploadResult
+		if event.Resumable {
+			result, err = s3ToDriveResumable(ctx, event, policy)
+		} else {
+			result, err = fileTransfer.S3ToDriveWithOptions(ctx, s3c, driveSvc, event.S3Bucket, event.S3Key, event.DriveFolderId, event.DriveFileName, event.MimeType, fileTransfer.S3ToDriveOptions{
+				Conflict: policy,
+			})
+		}
 		if err != nil {
-			return err
+			return response, err
 		}
+		log.Infof("driveFile=%s name=%s skipped=%t replaced=%v", result.FileId, result.Name, result.Skipped, result.Replaced)
+		response.Items = append(response.Items, fileTransfer.ManifestItem{
+			Path:     result.Name,
+			S3Key:    event.S3Key,
+			DriveId:  result.FileId,
+			Skipped:  result.Skipped,
+			Replaced: result.Replaced,
+		})
 
 	case "driveToS3":
-		log.Debugf("downloading from driveFile=%s to bucket=%s key=%s", event.DriveFileId, event.S3Bucket, event.S3Key)
-		err := fileTransfer.DriveToS3(ctx, s3c, driveSvc, event.DriveFileId, event.S3Bucket, event.S3Key)
+		format, err := fileTransfer.ParseExportFormat(event.ExportFormat)
+		if err != nil {
+			return response, err
+		}
+		log.Debugf("downloading from driveFile=%s to bucket=%s key=%s exportFormat=%s", event.DriveFileId, event.S3Bucket, event.S3Key, format)
+		err = fileTransfer.DriveToS3WithOptions(ctx, s3c, driveSvc, event.DriveFileId, event.S3Bucket, event.S3Key, fileTransfer.DriveToS3Options{
+			ExportFormat: format,
+		})
+		if err != nil {
+			return response, err
+		}
+		response.Items = append(response.Items, fileTransfer.ManifestItem{
+			Path:    path.Base(event.S3Key),
+			S3Key:   event.S3Key,
+			DriveId: event.DriveFileId,
+		})
+
+	case "s3PrefixToDriveFolder":
+		policy, err := fileTransfer.ParseConflictPolicy(event.Conflict)
+		if err != nil {
+			return response, err
+		}
+		log.Debugf("syncing bucket=%s prefix=%s to driveFolder=%s include=%v exclude=%v conflict=%s", event.S3Bucket, event.S3Prefix, event.DriveFolderId, event.Include, event.Exclude, policy)
+		response.Items, err = fileTransfer.S3PrefixToDriveFolder(ctx, s3c, driveSvc, event.S3Bucket, event.S3Prefix, event.DriveFolderId, fileTransfer.SyncOptions{
+			Include:  event.Include,
+			Exclude:  event.Exclude,
+			Conflict: policy,
+		})
+		if err != nil {
+			return response, err
+		}
+
+	case "driveFolderToS3Prefix":
+		format, err := fileTransfer.ParseExportFormat(event.ExportFormat)
 		if err != nil {
-			return err
+			return response, err
 		}
+		log.Debugf("syncing driveFolder=%s to bucket=%s prefix=%s include=%v exclude=%v exportFormat=%s limit=%d continue=%s", event.DriveFolderId, event.S3Bucket, event.S3Prefix, event.Include, event.Exclude, format, event.Limit, event.Continue)
+		response.Items, response.Continue, err = fileTransfer.DriveFolderToS3Prefix(ctx, s3c, driveSvc, event.DriveFolderId, event.S3Bucket, event.S3Prefix, fileTransfer.SyncOptions{
+			Include:  event.Include,
+			Exclude:  event.Exclude,
+			Download: fileTransfer.DriveToS3Options{ExportFormat: format},
+			Limit:    event.Limit,
+			Continue: event.Continue,
+		})
+		if err != nil {
+			return response, err
+		}
+
+	default:
+		return response, fmt.Errorf("Unknown direction: %s", event.Direction)
 	}
 
-	return nil
+	log.Infof("transferred %d files", len(response.Items))
+	return response, nil
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const emptyBodySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Signature of a signed request covers only the hash of the body, the authorizer doesn't see the body.
+// The body is compared here, as raw bytes base64 encoded by the request template,
+// because the input is JSON serialized again by the gateway and its hash differs from the signed one.
+func bodyMatches(authMode string, bodySha256 string, bodyBase64 string) bool {
+	if authMode != "signature" {
+		return true
+	}
+	body, err := base64.StdEncoding.DecodeString(bodyBase64)
+	if err != nil {
+		return false
+	}
+	// Without the header the authorizer signs the hash of an empty body
+	want := strings.ToLower(strings.TrimSpace(bodySha256))
+	if want == "" {
+		want = emptyBodySha256
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]) == want
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestBodyMatches(t *testing.T) {
+	body := base64.StdEncoding.EncodeToString([]byte(`{"text": "test"}`))
+	bodySha256 := "63314e2d73775c96ef96f982d8d8a7a9c5ada522f063937383a4e11cac7cbdec"
+	cases := []struct {
+		name       string
+		authMode   string
+		bodySha256 string
+		body       string
+		want       bool
+	}{
+		{"api key", "api-key", "", body, true},
+		{"signed", "signature", bodySha256, body, true},
+		{"signed upper case hash", "signature", "63314E2D73775C96EF96F982D8D8A7A9C5ADA522F063937383A4E11CAC7CBDEC", body, true},
+		{"signed empty body", "signature", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "", true},
+		{"signed empty body without hash", "signature", "", "", true},
+		{"changed body", "signature", bodySha256, base64.StdEncoding.EncodeToString([]byte(`{"text": "evil"}`)), false},
+		{"missing hash", "signature", "", body, false},
+		{"invalid base64", "signature", bodySha256, "not base64!", false},
+	}
+	for _, c := range cases {
+		if got := bodyMatches(c.authMode, c.bodySha256, c.body); got != c.want {
+			t.Errorf("%s: bodyMatches() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
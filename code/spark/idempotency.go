@@ -0,0 +1,18 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Inputs of the executions compared as JSON values, the stored input may be serialized differently
+func sameInput(stored string, requested string) bool {
+	var a, b any
+	if err := json.Unmarshal([]byte(stored), &a); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(requested), &b); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
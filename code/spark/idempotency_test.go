@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSameInput(t *testing.T) {
+	type tc struct {
+		name      string
+		stored    string
+		requested string
+		want      bool
+	}
+	tests := []tc{
+		{"identical", `{"a":1,"jobId":"x"}`, `{"a":1,"jobId":"x"}`, true},
+		{"key order and spacing", `{"jobId": "x", "a": 1}`, `{"a":1,"jobId":"x"}`, true},
+		{"nested", `{"a":{"b":[1,2]}}`, `{"a":{"b":[1,2]}}`, true},
+		{"different value", `{"a":1,"jobId":"x"}`, `{"a":2,"jobId":"x"}`, false},
+		{"extra field", `{"a":1,"jobId":"x"}`, `{"a":1,"b":2,"jobId":"x"}`, false},
+		{"array order", `{"a":[1,2]}`, `{"a":[2,1]}`, false},
+		{"invalid stored", `{`, `{}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameInput(tt.stored, tt.requested); got != tt.want {
+				t.Errorf("sameInput(%s, %s) = %v, want %v", tt.stored, tt.requested, got, tt.want)
+			}
+		})
+	}
+}
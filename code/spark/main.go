@@ -4,44 +4,64 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"math/rand"
+	"fmt"
 	"os"
-	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sfn/types"
 
 	"lambdalib/apiGwResponse"
-	"lambdalib/random"
+	"lambdalib/jobId"
+	"lambdalib/keyScope"
 )
 
 const injectIdKey = "jobId"
+// Alternative to the Idempotency-Key header, removed from the input
+const idempotencyInputKey = "idempotencyKey"
 var (
 	log  *zap.SugaredLogger
 	sfnc *sfn.Client
+	ids  *jobId.Generators
+	// Nil when QUOTA_TABLE isn't set, jobs are not limited then
+	jobs *jobQuota
 )
 
 type Request struct {
-	APIKeyID string `json:"apiKeyId"`
 	SfnArn string `json:"stateMachineArn"`
 	Input string `json:"input"`
 	TraceHeader string `json:"traceHeader"`
+	IdempotencyKey string `json:"idempotencyKey"`
+	// API Gateway resource path, selects the input schema
+	Route string `json:"route"`
+	// Set by authorizer-psk, empty means the request didn't pass it
+	KeyName string `json:"keyName"`
+	StateMachines string `json:"stateMachines"`
+	// Daily job limit of the key, set by authorizer-psk
+	DailyJobs string `json:"dailyJobs"`
+	// "signature" when the request was signed, its body has to match bodySha256
+	AuthMode string `json:"authMode"`
+	BodySha256 string `json:"bodySha256"`
+	// Raw request body, base64 encoded
+	Body string `json:"body"`
+}
+
+func (r Request) RouteKey() string {
+	return r.Route
 }
 
 type ResponseBody struct {
 	JobId string `json:"jobId"`
 }
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
-var seededRand *rand.Rand = random.NewRandom()
-
 func main() {
-	lambda.Start(HandleRequest)
+	lambda.Start(apiGwResponse.Wrap(log, HandleRequest))
 }
 func init() {
 	logConfig := zap.NewProductionConfig()
@@ -57,58 +77,169 @@ func init() {
 		log.Fatal("unable to load SDK config ", err)
 	}
 	sfnc = sfn.NewFromConfig(cfg)
-}
+	if table := os.Getenv("QUOTA_TABLE"); table != "" {
+		jobs = &jobQuota{client: dynamodb.NewFromConfig(cfg), table: table}
+	}
+
+	err = apiGwResponse.ConfigureFromEnv()
+	if err != nil {
+		log.Fatal("invalid route config ", err)
+	}
+
+	ids, err = jobId.FromEnv()
+	if err != nil {
+		log.Fatal("invalid job ID config ", err)
+	}
 
-func makeRandStr(length int) string {
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = letterBytes[seededRand.Intn(len(letterBytes))]
+	schemas, err = compileSchemas()
+	if err != nil {
+		log.Fatal("unable to compile input schemas ", err)
 	}
-	return string(b)
 }
 
-func HandleRequest(ctx context.Context, event Request) (events.APIGatewayProxyResponse, error) {
-	length_s := os.Getenv("ID_LEN")
-	length, err := strconv.Atoi(length_s)
+// Reserves a job of the daily quota. Release gives it back when no new execution was started.
+// Quota table failures let the request through, the API should not stop because of quota tracking.
+func reserveJob(ctx context.Context, event Request) (func(), error) {
+	noop := func() {}
+	if jobs == nil {
+		return noop, nil
+	}
+	limit, err := parseDailyJobs(event.DailyJobs)
 	if err != nil {
-		log.Fatal("ID_LEN expected number")
+		return noop, apiGwResponse.InternalErr("Failed to read the job quota", err)
 	}
-	if length < 1 {
-		log.Fatal("ID_LEN must be > 0")
+	if limit == 0 {
+		return noop, nil
 	}
-	log.Info("Authored by key ID: '", event.APIKeyID, "'")
-	if event.APIKeyID == "" {
-		log.Warn("Incoming api key id is empty")
+
+	now := time.Now()
+	counterKey := jobCounterKey(event.KeyName, now)
+	reserved, err := jobs.reserve(ctx, counterKey, limit, now)
+	if err != nil {
+		log.Error("daily job quota check failed, allowing request: ", err)
+		return noop, nil
+	}
+	if !reserved {
+		return noop, apiGwResponse.QuotaExceededErr(fmt.Sprintf("Daily quota of %d jobs is used up, it renews at 00:00 UTC", limit))
 	}
+	return func() {
+		err := jobs.release(ctx, counterKey)
+		if err != nil {
+			log.Error("failed to release reserved job: ", err)
+		}
+	}, nil
+}
 
-	randId := makeRandStr(length)
+func HandleRequest(ctx context.Context, event Request) (events.APIGatewayProxyResponse, error) {
+	log.Info("Authorized key name: '", event.KeyName, "' scoped to state machines: '", event.StateMachines, "'")
+	if event.KeyName == "" {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ForbiddenErr("The request was not authorized")
+	}
+	if !bodyMatches(event.AuthMode, event.BodySha256, event.Body) {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ForbiddenErr("The body doesn't match the signed x-content-sha256")
+	}
+	if !keyScope.AllowsStateMachine(event.StateMachines, event.SfnArn) {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ForbiddenErr("The API key is not allowed to start this job")
+	}
 
-	log.Info("Generated jobId: ", randId)
+	fields, err := validateInput(event.Route, event.Input)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.InternalErr("Failed to validate input data", err)
+	}
+	if len(fields) > 0 {
+		log.Info("Invalid input: ", fields)
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ValidationErr("Invalid request body", fields...)
+	}
 
 	var realInput map[string]any
 	err = json.Unmarshal([]byte(event.Input), &realInput)
 	if err != nil {
-		return events.APIGatewayProxyResponse{}, errors.Join(errors.New("Failed to decode input data"), err)
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ValidationErr("Request body must be a JSON object")
+	}
+
+	idempotencyKey := event.IdempotencyKey
+	if inputKey, ok := realInput[idempotencyInputKey].(string); ok {
+		if idempotencyKey == "" {
+			idempotencyKey = inputKey
+		}
+		delete(realInput, idempotencyInputKey)
+	}
+
+	gen := ids.For(event.Route)
+	var randId string
+	if idempotencyKey != "" {
+		// Same key from the same API key for the same state machine always gives the same jobId
+		randId = gen.Derive(event.KeyName + "\x00" + event.SfnArn + "\x00" + idempotencyKey)
+		log.Info("Idempotency key: '", idempotencyKey, "' jobId: ", randId)
+	} else {
+		randId, err = gen.New()
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, apiGwResponse.InternalErr("Failed to generate jobId", err)
+		}
+		log.Info("Generated jobId: ", randId)
 	}
 
 	realInput[injectIdKey] = randId
 
 	encodedInput, err := json.Marshal(realInput)
 	if err != nil {
-		return events.APIGatewayProxyResponse{}, errors.Join(errors.New("Failed re-encode input data"), err)
+		return events.APIGatewayProxyResponse{}, apiGwResponse.InternalErr("Failed re-encode input data", err)
 	}
 	encodedInput_s := string(encodedInput)
 
+	release, err := reserveJob(ctx, event)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	requested := time.Now()
+	// Execution is named after the jobId, the status lambda finds it by the name
 	result, err := sfnc.StartExecution(ctx, &sfn.StartExecutionInput{
+		Name: &randId,
 		Input: &encodedInput_s,
 		StateMachineArn: &event.SfnArn,
 		TraceHeader: &event.TraceHeader,
 	})
 	if err != nil {
-		return events.APIGatewayProxyResponse{}, errors.Join(errors.New("Failed to Start Execution"), err)
+		release()
+	}
+	var exists *types.ExecutionAlreadyExists
+	if idempotencyKey != "" && errors.As(err, &exists) {
+		log.Info("Execution for the idempotency key already exists, jobId: ", randId)
+		execArn, err := jobId.ExecutionArn(event.SfnArn, randId)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, apiGwResponse.InternalErr("Misconfigured route", err)
+		}
+		existing, err := sfnc.DescribeExecution(ctx, &sfn.DescribeExecutionInput{
+			ExecutionArn: &execArn,
+		})
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, apiGwResponse.AwsErr("Failed to describe the existing execution", err)
+		}
+		if existing.Input == nil || !sameInput(*existing.Input, encodedInput_s) {
+			return events.APIGatewayProxyResponse{}, apiGwResponse.ConflictErr("The idempotency key was already used with a different request body")
+		}
+		return apiGwResponse.OkResponse(ResponseBody{
+			JobId: randId,
+		})
+	}
+	if errors.As(err, &exists) {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ConflictErr("Job with the same jobId already exists, please retry")
+	}
+	var limit *types.ExecutionLimitExceeded
+	if errors.As(err, &limit) {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.UpstreamUnavailableErr("Too many running jobs, please retry later", err)
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.AwsErr("Failed to Start Execution", err)
 	}
 
 	log.Info("StepFunctions Execution Arn: ", result.ExecutionArn)
+	if idempotencyKey != "" && existingExecution(result.StartDate, requested) {
+		// Retry while the job runs with identical input, no new job was started
+		log.Info("Execution for the idempotency key is already running, jobId: ", randId)
+		release()
+	}
 
 	return apiGwResponse.OkResponse(ResponseBody{
 		JobId: randId,
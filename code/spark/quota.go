@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Daily jobs of each key, counted in QUOTA_TABLE shared with authorizer-psk.
+// A job is reserved before the execution starts and released when no new execution was started,
+// so invalid requests and idempotent retries don't count.
+type jobQuota struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// Clock skew between Lambda and Step Functions tolerated when telling an existing execution from a new one
+const startSkew = time.Second
+
+// StartExecution with the name and input of a running execution succeeds and returns that execution.
+// It was started by an earlier request when its start date precedes this request.
+func existingExecution(startDate *time.Time, requested time.Time) bool {
+	return startDate != nil && startDate.Before(requested.Add(-startSkew))
+}
+
+// Counter of one key in one UTC day
+func jobCounterKey(keyName string, now time.Time) string {
+	return "jobs#" + keyName + "#" + now.UTC().Format(time.DateOnly)
+}
+
+// Limit set by the authorizer, empty or zero means no limit
+func parseDailyJobs(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errors.New("Invalid daily job limit: " + value)
+	}
+	return limit, nil
+}
+
+// Adds one job unless the limit is reached. False when it is reached.
+func (q *jobQuota) reserve(ctx context.Context, counterKey string, limit int, now time.Time) (bool, error) {
+	_, err := q.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(q.table),
+		Key:                 map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: counterKey}},
+		UpdateExpression:    aws.String("ADD #count :one SET expires = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(#count) OR #count < :max"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": "count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":     &types.AttributeValueMemberN{Value: "1"},
+			":max":     &types.AttributeValueMemberN{Value: strconv.Itoa(limit)},
+			":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UTC().Truncate(24*time.Hour).Add(48*time.Hour).Unix(), 10)},
+		},
+	})
+	var limitReached *types.ConditionalCheckFailedException
+	if errors.As(err, &limitReached) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Returns the reserved job when no new execution was started
+func (q *jobQuota) release(ctx context.Context, counterKey string) error {
+	_, err := q.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(q.table),
+		Key:                 map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: counterKey}},
+		UpdateExpression:    aws.String("ADD #count :minusOne"),
+		ConditionExpression: aws.String("#count > :zero"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": "count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":minusOne": &types.AttributeValueMemberN{Value: "-1"},
+			":zero":     &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	var empty *types.ConditionalCheckFailedException
+	if errors.As(err, &empty) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobCounterKey(t *testing.T) {
+	// Day of the counter is the UTC day, the quota renews at 00:00 UTC
+	now := time.Date(2026, 3, 2, 1, 0, 0, 0, time.FixedZone("CET", 3600))
+	if got := jobCounterKey("GR/cz", now); got != "jobs#GR/cz#2026-03-02" {
+		t.Errorf("jobCounterKey() = %q", got)
+	}
+	if got := jobCounterKey("GR/cz", now.Add(-time.Minute)); got != "jobs#GR/cz#2026-03-01" {
+		t.Errorf("jobCounterKey() before UTC midnight = %q", got)
+	}
+}
+
+func TestParseDailyJobs(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"50", 50, false},
+		{" 5 ", 5, false},
+		{"-1", 0, true},
+		{"many", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDailyJobs(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDailyJobs(%q) = %d, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestExistingExecution(t *testing.T) {
+	requested := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		started := requested.Add(-d)
+		return &started
+	}
+	tests := []struct {
+		name      string
+		startDate *time.Time
+		want      bool
+	}{
+		{"started by this request", ago(-50 * time.Millisecond), false},
+		{"clock skew", ago(500 * time.Millisecond), false},
+		{"started by earlier request", ago(time.Minute), true},
+		{"missing start date", nil, false},
+	}
+	for _, tt := range tests {
+		if got := existingExecution(tt.startDate, requested); got != tt.want {
+			t.Errorf("existingExecution() %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"lambdalib/apiGwResponse"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// Input schema of each route (API Gateway resource path).
+// State machine ARNs are not known at build time, so the route identifies the state machine.
+var schemaRoutes = map[string]string{
+	"/unstable/v2/dmq/make":          "schemas/dmq-make.json",
+	"/unstable/v2/video-render/reel": "schemas/video-render-reel.json",
+}
+
+var (
+	schemas        map[string]*jsonschema.Schema
+	messagePrinter = message.NewPrinter(language.English)
+)
+
+func compileSchemas() (map[string]*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	compiler.AssertContent()
+
+	compiled := make(map[string]*jsonschema.Schema, len(schemaRoutes))
+	for route, file := range schemaRoutes {
+		data, err := schemaFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Invalid JSON in %s", file), err)
+		}
+		err = compiler.AddResource(file, doc)
+		if err != nil {
+			return nil, err
+		}
+		compiled[route], err = compiler.Compile(file)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("Invalid schema %s", file), err)
+		}
+	}
+	return compiled, nil
+}
+
+// Leaf errors of the validation tree, one per problem.
+// Missing properties are reported one by one at their own pointer, e.g. "/text".
+func fieldErrors(err *jsonschema.ValidationError) []apiGwResponse.FieldError {
+	if len(err.Causes) == 0 {
+		var missing []string
+		switch k := err.ErrorKind.(type) {
+		case *kind.Required:
+			missing = k.Missing
+		case *kind.DependentRequired:
+			missing = k.Missing
+		}
+		if len(missing) > 0 {
+			var fields []apiGwResponse.FieldError
+			for _, prop := range missing {
+				fields = append(fields, apiGwResponse.FieldError{
+					Field:   pointer(append(slices.Clone(err.InstanceLocation), prop)),
+					Message: (&kind.Required{Missing: []string{prop}}).LocalizedString(messagePrinter),
+				})
+			}
+			return fields
+		}
+		return []apiGwResponse.FieldError{{
+			Field:   pointer(err.InstanceLocation),
+			Message: err.ErrorKind.LocalizedString(messagePrinter),
+		}}
+	}
+	var fields []apiGwResponse.FieldError
+	for _, cause := range err.Causes {
+		fields = append(fields, fieldErrors(cause)...)
+	}
+	return fields
+}
+
+// JSON pointer (RFC 6901) of the location, empty for the whole document
+func pointer(location []string) string {
+	var b strings.Builder
+	for _, token := range location {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
+
+// Validates input against the schema of the route. Routes without schema are not validated.
+// Returns nil when the input is valid.
+func validateInput(route string, input string) ([]apiGwResponse.FieldError, error) {
+	schema, ok := schemas[route]
+	if !ok {
+		log.Warn("No input schema for route: '", route, "'")
+		return nil, nil
+	}
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(input))
+	if err != nil {
+		return []apiGwResponse.FieldError{{Message: "Body is not valid JSON"}}, nil
+	}
+
+	err = schema.Validate(doc)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		// Validator visits properties in random order, keep the response stable
+		fields := fieldErrors(validationErr)
+		slices.SortStableFunc(fields, func(a, b apiGwResponse.FieldError) int {
+			return cmp.Or(strings.Compare(a.Field, b.Field), strings.Compare(a.Message, b.Message))
+		})
+		return fields, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		input  string
+		fields []string
+	}{
+		{
+			name:  "valid dmq",
+			route: "/unstable/v2/dmq/make",
+			input: `{"date": "2025-05-28", "text": "Quote", "sourceDriveId": "0AHp", "sourceDrivePath": "Photos", "destDriveFolderId": "1t2J"}`,
+		},
+		{
+			name:   "dmq missing text and folder",
+			route:  "/unstable/v2/dmq/make",
+			input:  `{"date": "2025-05-28", "sourceDriveId": "0AHp", "destDriveFolderId": "1t2J"}`,
+			fields: []string{"/sourceDriveFolderId", "/sourceDrivePath", "/text"},
+		},
+		{
+			name:   "dmq wrong types",
+			route:  "/unstable/v2/dmq/make",
+			input:  `{"date": "2025-05-28", "text": "Quote", "sourceDriveId": "0AHp", "sourceDriveFolderId": "1RSO", "destDriveFolderId": "1t2J", "fallback": {"chain": ["nextDay"]}, "preprocess": {"quality": 101}}`,
+			fields: []string{"/fallback/chain/0", "/preprocess/quality"},
+		},
+		{
+			name:   "video render bad workflow",
+			route:  "/unstable/v2/video-render/reel",
+			input:  `{"videoDriveId": "a", "videoFileId": "b", "srtDriveId": "c", "srtDrivePath": "d", "destinationFolderId": "e", "deliveryWorkflow": "email", "deliveryParams": "{}", "errDeliveryParams": "{}"}`,
+			fields: []string{"/deliveryWorkflow"},
+		},
+		{
+			name:  "unknown route",
+			route: "/unstable/v2/other",
+			input: `{}`,
+		},
+		{
+			name:   "not json",
+			route:  "/unstable/v2/dmq/make",
+			input:  `{"date": `,
+			fields: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := validateInput(tt.route, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fields) != len(tt.fields) {
+				t.Fatalf("got %v, want fields %v", fields, tt.fields)
+			}
+			for i, field := range fields {
+				if field.Field != tt.fields[i] {
+					t.Errorf("field %d = %q, want %q (%s)", i, field.Field, tt.fields[i], field.Message)
+				}
+			}
+		})
+	}
+}
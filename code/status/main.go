@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sfn/types"
+
+	"lambdalib/apiGwResponse"
+	"lambdalib/jobId"
+	"lambdalib/keyScope"
+)
+
+// How many latest history events are searched for the current step
+const historyDepth = 50
+
+var (
+	log  *zap.SugaredLogger
+	sfnc *sfn.Client
+)
+
+type Request struct {
+	// Set by authorizer-psk, empty means the request didn't pass it
+	KeyName       string `json:"keyName"`
+	StateMachines string `json:"stateMachines"`
+	SfnArn        string `json:"stateMachineArn"`
+	JobId   string `json:"jobId"`
+	// API Gateway resource path
+	Route string `json:"route"`
+}
+
+func (r Request) RouteKey() string {
+	return r.Route
+}
+
+type ResponseBody struct {
+	JobId     string     `json:"jobId"`
+	State     string     `json:"state"`
+	Step      string     `json:"step,omitempty"`
+	StartedAt time.Time  `json:"startedAt"`
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Cause     string     `json:"cause,omitempty"`
+	// Output of the finished job, e.g. locations of the results
+	Output json.RawMessage `json:"output,omitempty"`
+}
+
+func main() {
+	lambda.Start(apiGwResponse.Wrap(log, HandleRequest))
+}
+func init() {
+	logConfig := zap.NewProductionConfig()
+	logConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	logger, _ := logConfig.Build()
+	defer logger.Sync()
+	log = logger.Sugar()
+
+	ctx := context.Background()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatal("unable to load SDK config ", err)
+	}
+	sfnc = sfn.NewFromConfig(cfg)
+
+	err = apiGwResponse.ConfigureFromEnv()
+	if err != nil {
+		log.Fatal("invalid route config ", err)
+	}
+}
+
+// Name of the state the execution entered last
+func currentStep(ctx context.Context, execArn string) (string, error) {
+	history, err := sfnc.GetExecutionHistory(ctx, &sfn.GetExecutionHistoryInput{
+		ExecutionArn:         &execArn,
+		ReverseOrder:         true,
+		MaxResults:           historyDepth,
+		IncludeExecutionData: aws.Bool(false),
+	})
+	if err != nil {
+		return "", apiGwResponse.AwsErr("Failed to get execution history", err)
+	}
+	for _, event := range history.Events {
+		if event.StateEnteredEventDetails != nil && event.StateEnteredEventDetails.Name != nil {
+			return *event.StateEnteredEventDetails.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// Output which isn't JSON is left out
+func statusBody(jobId string, execution *sfn.DescribeExecutionOutput) ResponseBody {
+	body := ResponseBody{
+		JobId:     jobId,
+		State:     string(execution.Status),
+		StartedAt: aws.ToTime(execution.StartDate),
+		StoppedAt: execution.StopDate,
+		Error:     aws.ToString(execution.Error),
+		Cause:     aws.ToString(execution.Cause),
+	}
+	if execution.Output != nil && json.Valid([]byte(*execution.Output)) {
+		body.Output = json.RawMessage(*execution.Output)
+	}
+	return body
+}
+
+func HandleRequest(ctx context.Context, event Request) (events.APIGatewayProxyResponse, error) {
+	log.Info("Authorized key name: '", event.KeyName, "' scoped to state machines: '", event.StateMachines, "'")
+	log.Info("Status of jobId: ", event.JobId)
+
+	if event.KeyName == "" {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ForbiddenErr("The request was not authorized")
+	}
+	// Output and failure cause of the job are as sensitive as starting it
+	if !keyScope.AllowsStateMachine(event.StateMachines, event.SfnArn) {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ForbiddenErr("The API key is not allowed to read this job")
+	}
+	if event.JobId == "" {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ValidationErr("jobId is required")
+	}
+	// ':' or '/' would change the meaning of the ARN
+	if !jobId.Valid(event.JobId) {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.ValidationErr(fmt.Sprintf("Invalid jobId %s", event.JobId))
+	}
+	execArn, err := jobId.ExecutionArn(event.SfnArn, event.JobId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, apiGwResponse.InternalErr("Misconfigured route", err)
+	}
+
+	execution, err := sfnc.DescribeExecution(ctx, &sfn.DescribeExecutionInput{
+		ExecutionArn: &execArn,
+	})
+	if err != nil {
+		var notFound *types.ExecutionDoesNotExist
+		if errors.As(err, &notFound) {
+			return events.APIGatewayProxyResponse{}, apiGwResponse.NotFoundErr(fmt.Sprintf("Job %s not found", event.JobId))
+		}
+		var invalid *types.InvalidName
+		if errors.As(err, &invalid) {
+			return events.APIGatewayProxyResponse{}, apiGwResponse.ValidationErr(fmt.Sprintf("Invalid jobId %s", event.JobId))
+		}
+		return events.APIGatewayProxyResponse{}, apiGwResponse.AwsErr("Failed to describe execution", err)
+	}
+
+	body := statusBody(event.JobId, execution)
+	// For failed jobs this is the step which failed
+	if execution.Status != types.ExecutionStatusSucceeded {
+		body.Step, err = currentStep(ctx, execArn)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+	}
+
+	log.Info("Job state: ", body.State, " step: ", body.Step)
+	return apiGwResponse.OkResponse(body)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sfn/types"
+
+	"lambdalib/apiGwResponse"
+)
+
+func TestStatusBody(t *testing.T) {
+	started := time.Date(2025, 5, 28, 10, 0, 0, 0, time.UTC)
+	stopped := started.Add(time.Minute)
+
+	type tc struct {
+		name      string
+		execution sfn.DescribeExecutionOutput
+		expected  ResponseBody
+	}
+
+	tests := []tc{
+		{
+			name: "running",
+			execution: sfn.DescribeExecutionOutput{
+				Status:    types.ExecutionStatusRunning,
+				StartDate: &started,
+			},
+			expected: ResponseBody{JobId: "job", State: "RUNNING", StartedAt: started},
+		},
+		{
+			name: "succeeded with output",
+			execution: sfn.DescribeExecutionOutput{
+				Status:    types.ExecutionStatusSucceeded,
+				StartDate: &started,
+				StopDate:  &stopped,
+				Output:    aws.String(`{"s3Key":"dmq/job/result.png"}`),
+			},
+			expected: ResponseBody{JobId: "job", State: "SUCCEEDED", StartedAt: started, StoppedAt: &stopped, Output: []byte(`{"s3Key":"dmq/job/result.png"}`)},
+		},
+		{
+			name: "output which isn't json",
+			execution: sfn.DescribeExecutionOutput{
+				Status:    types.ExecutionStatusSucceeded,
+				StartDate: &started,
+				StopDate:  &stopped,
+				Output:    aws.String(`done`),
+			},
+			expected: ResponseBody{JobId: "job", State: "SUCCEEDED", StartedAt: started, StoppedAt: &stopped},
+		},
+		{
+			name: "failed",
+			execution: sfn.DescribeExecutionOutput{
+				Status:    types.ExecutionStatusFailed,
+				StartDate: &started,
+				StopDate:  &stopped,
+				Error:     aws.String("TransientError"),
+				Cause:     aws.String("Drive responded 503"),
+			},
+			expected: ResponseBody{JobId: "job", State: "FAILED", StartedAt: started, StoppedAt: &stopped, Error: "TransientError", Cause: "Drive responded 503"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := statusBody("job", &test.execution)
+			if got.JobId != test.expected.JobId || got.State != test.expected.State || !got.StartedAt.Equal(test.expected.StartedAt) ||
+				got.Error != test.expected.Error || got.Cause != test.expected.Cause || string(got.Output) != string(test.expected.Output) {
+				t.Errorf("statusBody() = %+v, want %+v", got, test.expected)
+			}
+			if (got.StoppedAt == nil) != (test.expected.StoppedAt == nil) {
+				t.Errorf("statusBody() stoppedAt = %v, want %v", got.StoppedAt, test.expected.StoppedAt)
+			}
+		})
+	}
+}
+
+// Rejected requests never reach Step Functions, so sfnc isn't needed
+func TestHandleRequestRejects(t *testing.T) {
+	sfnArn := "arn:aws:states:eu-central-1:123456789012:stateMachine:DMQs-Make-a1b2c3"
+
+	type tc struct {
+		name   string
+		event  Request
+		status int
+	}
+
+	tests := []tc{
+		{name: "not authorized", event: Request{StateMachines: "*", SfnArn: sfnArn, JobId: "abc"}, status: http.StatusForbidden},
+		{name: "other state machine", event: Request{KeyName: "key", StateMachines: "VideoRender-*", SfnArn: sfnArn, JobId: "abc"}, status: http.StatusForbidden},
+		{name: "missing scope", event: Request{KeyName: "key", SfnArn: sfnArn, JobId: "abc"}, status: http.StatusForbidden},
+		{name: "missing jobId", event: Request{KeyName: "key", StateMachines: "DMQs-Make-*", SfnArn: sfnArn}, status: http.StatusBadRequest},
+		{name: "colon in jobId", event: Request{KeyName: "key", StateMachines: "*", SfnArn: sfnArn, JobId: "abc:def"}, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := HandleRequest(context.Background(), test.event)
+			var apiErr *apiGwResponse.ErrApi
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("HandleRequest() error = %v, want API error", err)
+			}
+			if apiErr.Status() != test.status {
+				t.Errorf("HandleRequest() status = %d, want %d", apiErr.Status(), test.status)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
@@ -20,6 +21,8 @@ import (
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 
+	"lambdalib/driveList"
+	"lambdalib/drivePath"
 	"lambdalib/fileTransfer"
 	"lambdalib/random"
 )
@@ -28,6 +31,9 @@ var (
 	s3c      *s3.Client
 	log      *zap.SugaredLogger
 	driveSvc *drive.Service
+	resolver *drivePath.Resolver
+
+	transferOpts fileTransfer.DriveToS3Options
 
 	videoFormats = map[string]int{".mp4": 10, ".m4v": 9, ".avi": 8, ".mov": 7}
 	audioFormats = map[string]int{".wav": 10, ".m4a": 9, ".mp3": 8, ".ogg": 7}
@@ -36,11 +42,14 @@ var (
 type Event struct {
 	JobId          string `json:"jobId"`
 	SourceFolderId string `json:"sourceDriveFolderId"`
-	DriveId        string `json:"driveId"`
-	VideoFileId    string `json:"videoFileId"`
+	// Optional path like "Videos/2025/Talk", relative to sourceDriveFolderId or to the root of driveId
+	SourceFolderPath string `json:"sourceDrivePath,omitempty"`
+	DriveId          string `json:"driveId"`
+	VideoFileId      string `json:"videoFileId"`
 }
 
 func main() {
+	initClients()
 	lambda.Start(HandleRequest)
 }
 func init() {
@@ -49,7 +58,10 @@ func init() {
 	logger, _ := logConfig.Build()
 	defer logger.Sync()
 	log = logger.Sugar()
+}
 
+// Not in init, so tests of the package run without AWS and Google access
+func initClients() {
 	ctx := context.Background()
 
 	cfg, err := config.LoadDefaultConfig(ctx)
@@ -64,6 +76,31 @@ func init() {
 	if err != nil {
 		log.Fatal("Error initializig G drive client service: ", err)
 	}
+
+	transferOpts, err = getTransferOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Debugf("Transfer part size=%d concurrency=%d (0 = default)", transferOpts.PartSize, transferOpts.Concurrency)
+}
+
+func getTransferOptions() (fileTransfer.DriveToS3Options, error) {
+	opts := fileTransfer.DriveToS3Options{}
+	if partSize := os.Getenv("UPLOAD_PART_SIZE_MB"); partSize != "" {
+		size, err := strconv.Atoi(partSize)
+		if err != nil || size < 5 {
+			return opts, fmt.Errorf("UPLOAD_PART_SIZE_MB expected number >= 5, got %q", partSize)
+		}
+		opts.PartSize = int64(size) * 1024 * 1024
+	}
+	if concurrency := os.Getenv("UPLOAD_CONCURRENCY"); concurrency != "" {
+		count, err := strconv.Atoi(concurrency)
+		if err != nil || count < 1 {
+			return opts, fmt.Errorf("UPLOAD_CONCURRENCY expected number > 0, got %q", concurrency)
+		}
+		opts.Concurrency = count
+	}
+	return opts, nil
 }
 
 func GCPConfig(ctx context.Context, ssmc *ssm.Client) (*string, error) {
@@ -100,6 +137,7 @@ func InitDrive(ctx context.Context, ssmc *ssm.Client) error {
 	}
 
 	driveSvc = driveService
+	resolver = drivePath.NewResolver(driveSvc)
 	return nil
 }
 
@@ -130,56 +168,39 @@ func getBucket(jobId string) (string, string) {
 }
 
 func FindStemsFolder(ctx context.Context, folderId string, driveId string) (string, error) {
-	stemsIter, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false and mimeType = 'application/vnd.google-apps.folder' and name = 'Stems'", folderId)).
-		Fields("files(id, name)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	folders, err := resolver.Children(ctx, driveId, folderId, drivePath.FolderMime)
 	if err != nil {
 		return "", errors.Join(errors.New(fmt.Sprint("Error finding stems in: ", folderId)), err)
 	}
-	if stemsIter.NextPageToken != "" {
-		log.Warn("Next Page Token is present. Pagination is not implemented. This may cause an absence of materials.")
-	}
-
-	if len(stemsIter.Files) == 0 {
-		return "", nil
+	for _, folder := range folders {
+		// Only a real folder, shortcuts are found by FindStemsOCDLink
+		if folder.Name == "Stems" && folder.ShortcutDetails == nil {
+			return folder.Id, nil
+		}
 	}
-
-	return stemsIter.Files[0].Id, nil
+	return "", nil
 }
 
 func FindStemsOCDLink(ctx context.Context, folderId string, driveId string) (string, error) {
-	stemsIter, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false and mimeType = 'application/vnd.google-apps.shortcut'", folderId)).
-		Fields("files(id, name, shortcutDetails)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	folders, err := resolver.Children(ctx, driveId, folderId, drivePath.FolderMime)
 	if err != nil {
 		return "", errors.Join(errors.New(fmt.Sprint("Error finding stems in: ", folderId)), err)
 	}
-	if stemsIter.NextPageToken != "" {
-		log.Warn("Next Page Token is present. Pagination is not implemented. This may cause an absence of materials.")
-	}
 
-	if len(stemsIter.Files) > 1 {
+	var links []*drive.File
+	for _, folder := range folders {
+		// Resolver follows shortcuts, ShortcutDetails is kept on the target
+		if folder.ShortcutDetails != nil {
+			links = append(links, folder)
+		}
+	}
+	if len(links) > 1 {
 		log.Warn("When searching for stems, encountered more than 1 link in folder ", folderId, " Only the first link pointing to folder type is followed.")
 	}
-
-	for _, file := range stemsIter.Files {
-		if file.ShortcutDetails.TargetMimeType == "application/vnd.google-apps.folder" {
-			return file.ShortcutDetails.TargetId, nil
-		}
+	if len(links) == 0 {
+		return "", nil
 	}
-	return "", nil
+	return links[0].Id, nil
 }
 
 func FindStems(ctx context.Context, folderId string, driveId string) (string, error) {
@@ -202,31 +223,22 @@ func FindStems(ctx context.Context, folderId string, driveId string) (string, er
 }
 
 func FilterFiles(ctx context.Context, stemsId string, driveId string, skipVideo bool) (*drive.File, []*drive.File, error) {
-	// There is a possible nextPageToken field.
-	// I do ignore it as I expect only a few files.
-	files, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false", stemsId)).
-		Fields("nextPageToken, files(id, name, mimeType)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	files, err := driveList.All(ctx, driveSvc, driveList.Query{
+		Q:       driveList.InFolder(stemsId, ""),
+		Fields:  "id, name, mimeType",
+		DriveId: driveId,
+	})
 	if err != nil {
 		return nil, nil, errors.Join(errors.New(fmt.Sprint("Error listing files in:", stemsId)), err)
 	}
-	if files.NextPageToken != "" {
-		log.Warn("Next Page Token is present. Pagination is not implemented. This may cause an absence of materials.")
-	}
 
 	var audioFiles []*drive.File
 	var videoFile *drive.File
 	videoPrio := 0
 
-	filesShuffled := files.Files
+	filesShuffled := files
 	rand := random.NewRandom()
-	random.Shuffle(rand, files.Files)
+	random.Shuffle(rand, files)
 
 	for _, f := range filesShuffled {
 		normalisedName := Sanitize(f.Name)
@@ -272,7 +284,12 @@ func HandleRequest(ctx context.Context, event Event) error {
 	log.Infof("jobid=%s", event.JobId)
 	targetBucket, targetKey := getBucket(event.JobId)
 
-	stems, err := FindStems(ctx, event.SourceFolderId, event.DriveId)
+	sourceFolderId, err := resolver.FolderId(ctx, event.DriveId, event.SourceFolderId, event.SourceFolderPath)
+	if err != nil {
+		return err
+	}
+
+	stems, err := FindStems(ctx, sourceFolderId, event.DriveId)
 	if err != nil {
 		return err
 	}
@@ -290,14 +307,14 @@ func HandleRequest(ctx context.Context, event Event) error {
 	}
 
 	bKey := fmt.Sprintf("%svideo/video%s", targetKey, filepath.Ext(videoFile.Name)) // BUG: When file doesn't exist, this fails
-	err = fileTransfer.DriveToS3(ctx, s3c, driveSvc, videoFileId, targetBucket, bKey) // TODO: add ability to append file extension in file transfer lib
+	err = fileTransfer.DriveToS3WithOptions(ctx, s3c, driveSvc, videoFileId, targetBucket, bKey, transferOpts) // TODO: add ability to append file extension in file transfer lib
 	if err != nil {
 		return err
 	}
 
 	for i, audioFile := range audioFiles {
 		bKey := fmt.Sprintf("%saudio/audio_%d%s", targetKey, i, filepath.Ext(audioFile.Name))
-		err = fileTransfer.DriveToS3(ctx, s3c, driveSvc, audioFile.Id, targetBucket, bKey)
+		err = fileTransfer.DriveToS3WithOptions(ctx, s3c, driveSvc, audioFile.Id, targetBucket, bKey, transferOpts)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestGetTransferOptions(t *testing.T) {
+	type tc struct {
+		name        string
+		partSize    string
+		concurrency string
+		expectedMb  int64
+		expectedCon int
+		wantErr     bool
+	}
+
+	tests := []tc{
+		{name: "not set", expectedMb: 0, expectedCon: 0},
+		{name: "both set", partSize: "16", concurrency: "4", expectedMb: 16, expectedCon: 4},
+		{name: "minimum part size", partSize: "5", expectedMb: 5},
+		{name: "part size below minimum", partSize: "4", wantErr: true},
+		{name: "part size not a number", partSize: "16MB", wantErr: true},
+		{name: "zero concurrency", concurrency: "0", wantErr: true},
+		{name: "concurrency not a number", concurrency: "many", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("UPLOAD_PART_SIZE_MB", test.partSize)
+			t.Setenv("UPLOAD_CONCURRENCY", test.concurrency)
+			got, err := getTransferOptions()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getTransferOptions() error = %v, wantErr %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got.PartSize != test.expectedMb*1024*1024 || got.Concurrency != test.expectedCon {
+				t.Errorf("getTransferOptions() = %+v, want part size %d MB concurrency %d", got, test.expectedMb, test.expectedCon)
+			}
+		})
+	}
+}
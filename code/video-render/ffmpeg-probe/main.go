@@ -150,7 +150,7 @@ func copyVideoIn(ctx context.Context, videoFile string, s3Bucket string, s3Key s
 
 	fileHandle, err := os.Create(videoFile)
 	if err != nil {
-		return errors.Join(fmt.Errorf("Error create a file for the download s3=%s key=%s file=%s", s3Bucket, s3Key, file), err)
+		return errors.Join(fmt.Errorf("Error create a file for the download s3=%s key=%s file=%s", s3Bucket, s3Key, videoFile), err)
 	}
 	defer fileHandle.Close()
 
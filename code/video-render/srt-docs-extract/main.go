@@ -19,6 +19,8 @@ import (
 	"google.golang.org/api/docs/v1"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
+
+	"lambdalib/drivePath"
 )
 
 var (
@@ -26,6 +28,7 @@ var (
 	log       *zap.SugaredLogger
 	driveSvc *drive.Service
 	docsSvc *docs.Service
+	resolver *drivePath.Resolver
 )
 const (
 	translationFilePrefix string = "SUB_"
@@ -35,6 +38,8 @@ const (
 
 type Event struct {
 	SourceFolderId string `json:"sourceDriveFolderId"`
+	// Optional path like "Videos/2025/Talk", relative to sourceDriveFolderId or to the root of driveId
+	SourceFolderPath string `json:"sourceDrivePath,omitempty"`
 	DriveId string `json:"driveId"`
 	JobId string `json:"jobId"`
 }
@@ -107,6 +112,7 @@ func InitDrive(ctx context.Context, ssmc *ssm.Client) error {
 
 	driveSvc = driveService
 	docsSvc = docsService
+	resolver = drivePath.NewResolver(driveSvc)
 	return nil
 }
 
@@ -126,26 +132,16 @@ func getBucket(jobId string) (string, string) {
 }
 
 func findTranslation(ctx context.Context, folderId string, driveId string) (*drive.File, error) {
-	files, err := driveSvc.Files.List().
-		Context(ctx).
-		Q(fmt.Sprintf("'%s' in parents and trashed = false and mimeType = 'application/vnd.google-apps.document'", folderId)).
-		Fields("nextPageToken, files(id, name, mimeType)").
-		Corpora("drive").
-		SupportsAllDrives(true).
-		IncludeItemsFromAllDrives(true).
-		DriveId(driveId).
-		Do()
+	// Shortcuts to documents are followed, so the translation can live in a shared folder
+	files, err := resolver.Children(ctx, driveId, folderId, drivePath.DocumentMime)
 	if err != nil {
 		return nil, errors.Join(errors.New(fmt.Sprint("Error finding translation doc in: ", folderId)), err)
 	}
-	if len(files.Files) == 0 {
-		return nil, errors.Join(errors.New(fmt.Sprint("There are no files in: ", folderId)), err)
-	}
-	if files.NextPageToken != "" {
-		log.Warn("Next Page Token is present. Pagination is not implemented. This may cause an absence of materials.")
+	if len(files) == 0 {
+		return nil, errors.New(fmt.Sprint("There are no files in: ", folderId))
 	}
 
-	for _, file := range files.Files {
+	for _, file := range files {
 		if strings.HasPrefix(file.Name, translationFilePrefix) {
 			return file, nil
 		}
@@ -201,7 +197,12 @@ func HandleRequest(ctx context.Context, event Event) (error) {
 	log.Infof("jobid=%s", event.JobId)
 	targetBucket, targetKey := getBucket(event.JobId)
 
-	transFile, err := findTranslation(ctx, event.SourceFolderId, event.DriveId)
+	sourceFolderId, err := resolver.FolderId(ctx, event.DriveId, event.SourceFolderId, event.SourceFolderPath)
+	if err != nil {
+		return err
+	}
+
+	transFile, err := findTranslation(ctx, sourceFolderId, event.DriveId)
 	if err != nil {
 		return err
 	}
@@ -1,5 +1,5 @@
 // **WARNING! The unstable APIs will be removed in future. If you experience a failure please check this page if the api was removed!**
-// Future (stable) APIs will contain a speacial header to notify you in case of breaking changes.
+// Deprecated APIs return Deprecation and Sunset headers, see deprecationAlert below.
 
 const APIKEY = "redacted"; // API key. This key is uniqe for your team and has its usage restrictions. DO NOT SHARE THIS WITH ANYONE!
 const API_URL = "api.isha-automations.fidifis.com"; // URL of API to call
@@ -22,7 +22,7 @@ function makeDmq() {
   const translated = sheet.getRange(activeCell.row, DMQ_TRASNS_COLUMN).getValue(); // Get translated text
 
   const date = sheet.getRange(activeCell.row, DATE_COLUMN).getValue(); // Get date
-  const stdDate = Utilities.formatDate(date, Session.getScriptTimeZone(), "yyyy-MM-dd'T'HH:mm:ss'Z'"); // Convert to standard RFC-3339 / ISO-8601 date format
+  const stdDate = Utilities.formatDate(date, Session.getScriptTimeZone(), "yyyy-MM-dd"); // Plain calendar date, it does not shift between time zones
 
   // Object with the request payload
   const options = {
@@ -35,6 +35,7 @@ function makeDmq() {
       // Here define the request parameters
       // Descriptions in docs on GitHub https://github.com/Fidifis/isha-automations/blob/main/docs/dmq/api.md
       "date": stdDate,
+      "timeZone": Session.getScriptTimeZone(), // IANA time zone of the sheet, e.g. "Asia/Kolkata"
       "text": translated,
       "sourceDriveId": IMAGES_DRIVE_ID, // TODO: Make this optional
       "sourceDriveFolderId": IMAGES_FOLDER_ID, // TODO: Make this optional
@@ -64,11 +65,17 @@ function makeDmq() {
  * @param {UrlFetchApp.HTTPResponse} response The response from UrlFetchApp
  */
 function deprecationAlert(response) {
-  const headers = response.getHeaders();
-  const deprecationMessage = headers['x-deprecated-version'];
+  // Header names may come in any case
+  const headers = {};
+  const raw = response.getHeaders();
+  for (const name in raw) {
+    headers[name.toLowerCase()] = raw[name];
+  }
 
-  if (deprecationMessage !== undefined) {
-    SpreadsheetApp.getUi().alert("The API calls you are currently using are deprecated! Please migrate to new version. Deprecation message: " + deprecationMessage);
+  if (headers['deprecation'] !== undefined || headers['sunset'] !== undefined) {
+    const sunset = headers['sunset'] !== undefined ? " It will be removed on " + headers['sunset'] + "." : "";
+    const link = headers['link'] !== undefined ? " See " + headers['link'] : "";
+    SpreadsheetApp.getUi().alert("The API version " + headers['api-version'] + " you are currently using is deprecated! Please migrate to new version." + sunset + link);
   }
 }
 
@@ -4,13 +4,25 @@ import * as fs from "fs";
 import * as utils from "./utils";
 import { Arch, GoLambda, HashFolder } from "./components/lambda";
 
+// Announced to clients by API-Version, Deprecation and Sunset headers.
+// Set deprecation and sunset (ISO 8601) before a route is removed.
+const routeVersions = {
+  "/unstable/v2/dmq/make": { version: "unstable-v2" },
+  "/unstable/v2/dmq/status/{jobId}": { version: "unstable-v2" },
+  "/unstable/v2/video-render/reel": { version: "unstable-v2" },
+  "/unstable/v2/video-render/status/{jobId}": { version: "unstable-v2" },
+};
+const corsAllowOrigin = "*";
+
 export default class CommonRes extends pulumi.ComponentResource {
   public readonly codeBucket: aws.s3.BucketV2;
   public readonly assetsBucket: aws.s3.BucketV2;
   public readonly procFilesBucket: aws.s3.BucketV2;
   public readonly gcpConfigParam: aws.ssm.Parameter;
+  public readonly quotaTable: aws.dynamodb.Table;
   // public readonly rngLambda: GoLambda;
   public readonly sparkLambda: GoLambda;
+  public readonly statusLambda: GoLambda;
   public readonly sparkApiGwExec: aws.iam.Role;
   public readonly sfnExec: aws.iam.Role;
 
@@ -101,6 +113,19 @@ export default class CommonRes extends pulumi.ComponentResource {
       { parent: this },
     );
 
+    // Rate limits and nonces of authorizer-psk, daily job counts of spark
+    this.quotaTable = new aws.dynamodb.Table(
+      "AuthQuota",
+      {
+        billingMode: "PAY_PER_REQUEST",
+        hashKey: "pk",
+        attributes: [{ name: "pk", type: "S" }],
+        ttl: { attributeName: "expires", enabled: true },
+        tags: meta.tags,
+      },
+      { parent: this },
+    );
+
     // this.rngLambda = new GoLambda(
     //   "RNG",
     //   {
@@ -127,9 +152,45 @@ export default class CommonRes extends pulumi.ComponentResource {
         architecture: Arch.arm,
         xray: true,
         logs: { retention: 30 },
+        rolePolicyStatements: [
+          {
+            actions: ["dynamodb:UpdateItem"],
+            resources: [this.quotaTable.arn],
+          },
+        ],
         env: {
           variables: {
-            ID_LEN: "8",
+            // Counts started jobs against dailyJobs of the authorizer
+            QUOTA_TABLE: this.quotaTable.name,
+            // Time sortable IDs, prefixed by the service
+            JOB_ID_KIND: "ulid",
+            JOB_ID_PREFIXES: JSON.stringify({
+              "/unstable/v2/dmq/make": "dmq_",
+              "/unstable/v2/video-render/reel": "vr_",
+            }),
+            ROUTE_VERSIONS: JSON.stringify(routeVersions),
+            CORS_ALLOW_ORIGIN: corsAllowOrigin,
+          },
+        },
+      },
+      { parent: this },
+    );
+
+    this.statusLambda = new GoLambda(
+      "Status",
+      {
+        tags: meta.tags,
+        source: {
+          code: "../bin/status.zip",
+          hash: HashFolder("../code/status/"),
+        },
+        architecture: Arch.arm,
+        xray: true,
+        logs: { retention: 30 },
+        env: {
+          variables: {
+            ROUTE_VERSIONS: JSON.stringify(routeVersions),
+            CORS_ALLOW_ORIGIN: corsAllowOrigin,
           },
         },
       },
@@ -182,7 +243,10 @@ export default class CommonRes extends pulumi.ComponentResource {
                       "lambda:InvokeFunction",
                     ],
                     // resources: [stateMachine.arn],
-                    resources: [this.sparkLambda.lambda.arn],
+                    resources: [
+                      this.sparkLambda.lambda.arn,
+                      this.statusLambda.lambda.arn,
+                    ],
                   },
                 ],
               },
@@ -258,8 +322,10 @@ export default class CommonRes extends pulumi.ComponentResource {
       assetsBucket: this.assetsBucket,
       procFilesBucket: this.procFilesBucket,
       gcpConfigParam: this.gcpConfigParam,
+      quotaTable: this.quotaTable,
       // rngLambda: this.rngLambda,
       sparkLambda: this.sparkLambda,
+      statusLambda: this.statusLambda,
       sparkApiGwExec: this.sparkApiGwExec,
       sfnExec: this.sfnExec,
     });
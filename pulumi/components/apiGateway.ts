@@ -41,8 +41,46 @@ export interface ApiGatewayProps {
   };
   authorizer?: aws.lambda.Function;
   usagePlans?: UsagePlan[];
+  // Answers browser preflight requests, the lambdas add CORS headers to their responses
+  cors?: {
+    allowOrigin: string;
+    allowHeaders: string[];
+  };
 }
 
+// Headers set by apiGwResponse, passed from lambda responses to the client
+const passedHeaders = [
+  "Content-Type",
+  "API-Version",
+  "Deprecation",
+  "Sunset",
+  "Link",
+  "Access-Control-Allow-Origin",
+  "Access-Control-Expose-Headers",
+];
+
+// Status and headers of the lambda response replace those of the integration.
+// Header names need the bracket syntax, names with a dash like API-Version are cut at the dash otherwise.
+const lambdaResponseTemplate = [
+  '$input.path("$.body")',
+  "#set($context.responseOverride.status = $input.path('$.statusCode'))",
+  "#set($headers = $input.path('$.headers'))",
+  ...passedHeaders.map(
+    (header) =>
+      `#if($headers.get('${header}'))#set($context.responseOverride.header["${header}"] = $headers.get('${header}'))#end`,
+  ),
+].join("\n");
+
+// Same shape as apiGwResponse.Problem of the lambdas
+const accessDeniedTemplate = JSON.stringify({
+  type: "about:blank",
+  title: "Forbidden",
+  status: 403,
+  detail: "The request was not authorized",
+  code: "$context.authorizer.reason",
+  requestId: "$context.requestId",
+});
+
 export default class RestApiGateway extends pulumi.ComponentResource {
   public readonly apiGateway: aws.apigateway.RestApi;
   public readonly deployment: aws.apigateway.Deployment;
@@ -55,7 +93,7 @@ export default class RestApiGateway extends pulumi.ComponentResource {
   ) {
     super("fidifis:aws:RestApiGateway", name, {}, opts);
 
-    const deplotmentVersion = 250621;
+    const deplotmentVersion = 261016;
 
     this.apiGateway = new aws.apigateway.RestApi(
       name,
@@ -72,6 +110,7 @@ export default class RestApiGateway extends pulumi.ComponentResource {
     );
 
     let globalAuthorizer: aws.apigateway.Authorizer | undefined;
+    let gatewayResponses: aws.apigateway.Response[] = [];
     if (args.authorizer) {
       const { apiAuthorizer } = this.CreateAuthorizer(
         name,
@@ -79,6 +118,7 @@ export default class RestApiGateway extends pulumi.ComponentResource {
         args.authorizer,
       );
       globalAuthorizer = apiAuthorizer;
+      gatewayResponses.push(this.CreateAccessDeniedResponse(name, args.cors));
     }
 
     let apiKeyActive = false;
@@ -96,6 +136,16 @@ export default class RestApiGateway extends pulumi.ComponentResource {
     let integrationsResp: aws.apigateway.IntegrationResponse[] = [];
 
     let madeResources = new Map<string, pulumi.Output<string>>();
+    let corsMethods = new Map<string, string[]>();
+    args.routes.forEach((route) => {
+      const path = route.path.toString();
+      corsMethods.set(path, [
+        ...(corsMethods.get(path) ?? ["OPTIONS"]),
+        route.method ?? "POST",
+      ]);
+    });
+    let corsDone = new Set<string>();
+
     args.routes.forEach((route, index) => {
       const method = route.method ?? "POST";
       const pathParts = route.path
@@ -130,6 +180,21 @@ export default class RestApiGateway extends pulumi.ComponentResource {
         madeResources.set(resourcePath, resource.id);
       });
 
+      if (args.cors && !corsDone.has(route.path.toString())) {
+        corsDone.add(route.path.toString());
+        this.CreatePreflight(
+          name,
+          index.toString(),
+          currentResource,
+          args.cors,
+          corsMethods.get(route.path.toString())!,
+          methods,
+          methodsResp,
+          integrations,
+          integrationsResp,
+        );
+      }
+
       // Create authorizer if needed
       let authorizer: aws.apigateway.Authorizer | null =
         globalAuthorizer ?? null;
@@ -215,9 +280,7 @@ export default class RestApiGateway extends pulumi.ComponentResource {
           httpMethod: integration200.httpMethod, // NOTE: All the dependencies here and around, are to create good dependency tree for correct deploy order.
           statusCode: methodResp200.statusCode,
           responseTemplates: route.requestTemplate
-            ? {
-                "application/json": '$input.path("$.body")\n#set($context.responseOverride.status = $input.path(\'$.statusCode\'))',
-              }
+            ? { "application/json": lambdaResponseTemplate }
             : undefined,
         },
         { parent: this },
@@ -283,6 +346,10 @@ export default class RestApiGateway extends pulumi.ComponentResource {
                   requestTemplate: route.requestTemplate,
                 };
               }),
+              cors: args.cors,
+              authorizer: args.authorizer?.arn,
+              accessDenied: args.authorizer ? accessDeniedTemplate : undefined,
+              lambdaResponse: lambdaResponseTemplate,
             })
             .apply((jsonArgs) => {
               return createHash("sha1").update(jsonArgs).digest("hex");
@@ -296,6 +363,7 @@ export default class RestApiGateway extends pulumi.ComponentResource {
           ...methodsResp,
           ...integrations,
           ...integrationsResp,
+          ...gatewayResponses,
         ],
       },
     );
@@ -397,6 +465,104 @@ export default class RestApiGateway extends pulumi.ComponentResource {
     });
   }
 
+  private CreatePreflight(
+    namePre: string,
+    namePost: string,
+    resourceId: pulumi.Output<string>,
+    cors: NonNullable<ApiGatewayProps["cors"]>,
+    allowMethods: string[],
+    methods: aws.apigateway.Method[],
+    methodsResp: aws.apigateway.MethodResponse[],
+    integrations: aws.apigateway.Integration[],
+    integrationsResp: aws.apigateway.IntegrationResponse[],
+  ) {
+    const corsHeaders = {
+      "method.response.header.Access-Control-Allow-Origin": `'${cors.allowOrigin}'`,
+      "method.response.header.Access-Control-Allow-Methods": `'${allowMethods.join(",")}'`,
+      "method.response.header.Access-Control-Allow-Headers": `'${cors.allowHeaders.join(",")}'`,
+    };
+
+    const method = new aws.apigateway.Method(
+      `${namePre}-MethodOptions-${namePost}`,
+      {
+        restApi: this.apiGateway.id,
+        resourceId,
+        httpMethod: "OPTIONS",
+        authorization: "NONE",
+      },
+      { parent: this },
+    );
+    methods.push(method);
+
+    const methodResp = new aws.apigateway.MethodResponse(
+      `${namePre}-MethodRespOptions-${namePost}`,
+      {
+        restApi: this.apiGateway.id,
+        resourceId,
+        httpMethod: method.httpMethod,
+        statusCode: "200",
+        responseParameters: Object.fromEntries(
+          Object.keys(corsHeaders).map((header) => [header, true]),
+        ),
+      },
+      { parent: this },
+    );
+    methodsResp.push(methodResp);
+
+    const integration = new aws.apigateway.Integration(
+      `${namePre}-IntegrationOptions-${namePost}`,
+      {
+        restApi: this.apiGateway.id,
+        resourceId,
+        httpMethod: method.httpMethod,
+        type: "MOCK",
+        requestTemplates: {
+          "application/json": '{"statusCode": 200}',
+        },
+      },
+      { parent: this },
+    );
+    integrations.push(integration);
+
+    const integrationResp = new aws.apigateway.IntegrationResponse(
+      `${namePre}-IntegrationRespOptions-${namePost}`,
+      {
+        restApi: this.apiGateway.id,
+        resourceId,
+        httpMethod: integration.httpMethod,
+        statusCode: methodResp.statusCode,
+        responseParameters: corsHeaders,
+      },
+      { parent: this },
+    );
+    integrationsResp.push(integrationResp);
+  }
+
+  // Denials of the authorizer as problem+json, code is the reason of the denial (e.g. rate-limited)
+  private CreateAccessDeniedResponse(
+    namePre: string,
+    cors: ApiGatewayProps["cors"],
+  ) {
+    return new aws.apigateway.Response(
+      `${namePre}-AccessDenied`,
+      {
+        restApiId: this.apiGateway.id,
+        responseType: "ACCESS_DENIED",
+        statusCode: "403",
+        responseTemplates: {
+          "application/json": accessDeniedTemplate,
+        },
+        responseParameters: {
+          "gatewayresponse.header.Content-Type": "'application/problem+json'",
+          ...(cors && {
+            "gatewayresponse.header.Access-Control-Allow-Origin": `'${cors.allowOrigin}'`,
+          }),
+        },
+      },
+      { parent: this },
+    );
+  }
+
   private CreateAuthorizer(
     namePre: string,
     namePost: string,
@@ -409,7 +575,9 @@ export default class RestApiGateway extends pulumi.ComponentResource {
         restApi: this.apiGateway.id,
         authorizerUri: authorizerFn.invokeArn,
         type: "REQUEST",
-        identitySource: "method.request.header.Authorization",
+        // Not cached, the authorizer counts requests and checks nonces. Without cache no identity source is required,
+        // requests with a signature instead of x-api-key reach the authorizer too.
+        authorizerResultTtlInSeconds: 0,
       },
       { parent: this },
     );
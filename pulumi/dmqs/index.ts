@@ -12,6 +12,7 @@ export interface DMQsProps {
   assetsBucket: aws.s3.BucketV2;
   gcpConfigParam: aws.ssm.Parameter;
   sparkLambda: GoLambda;
+  statusLambda: GoLambda;
   otpLambda: GoLambda;
   sparkApiGwExec: aws.iam.Role;
   sfnExec: aws.iam.Role;
@@ -2,6 +2,7 @@ import * as pulumi from "@pulumi/pulumi";
 import * as aws from "@pulumi/aws";
 import { Arch, GoLambda, HashFolder } from "../components/lambda";
 import { DMQsProps } from "./index";
+import { startCallerTemplate, statusCallerTemplate } from "../utils";
 
 export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
   const xray = true;
@@ -14,11 +15,17 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
           statements: [
             {
               effect: "Allow",
-              actions: ["s3:GetObject", "s3:PutObject"],
+              actions: ["s3:GetObject", "s3:PutObject", "s3:DeleteObject"],
               resources: [
                 pulumi.interpolate`${args.procFilesBucket.arn}/dmq/*`,
               ],
             },
+            {
+              // Missing fallback used list must be reported as NoSuchKey, not AccessDenied
+              effect: "Allow",
+              actions: ["s3:ListBucket"],
+              resources: [args.procFilesBucket.arn],
+            },
             {
               effect: "Allow",
               actions: ["s3:GetObject"],
@@ -66,7 +73,8 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
       },
       architecture: Arch.arm,
       timeout: 60,
-      memory: 128,
+      // Preprocessing decodes full resolution photos in memory
+      memory: 1024,
       logs: { retention: 30 },
       xray,
       env: {
@@ -109,11 +117,22 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
               Payload: {
                 jobId: "{% $states.input.jobId %}",
                 direction: "driveToS3",
-                driveFolderId: "{% $states.input.sourceDriveFolderId %}",
+                driveFolderId:
+                  "{% $exists($states.input.sourceDriveFolderId) ? $states.input.sourceDriveFolderId : '' %}",
+                driveFolderPath:
+                  "{% $exists($states.input.sourceDrivePath) ? $states.input.sourceDrivePath : '' %}",
                 driveId: "{% $states.input.sourceDriveId %}",
                 s3Bucket: args.procFilesBucket.id,
                 s3Key: "{% 'dmq/' & $states.input.jobId & '/request' %}",
                 date: "{% $states.input.date %}",
+                timeZone:
+                  "{% $exists($states.input.timeZone) ? $states.input.timeZone : '' %}",
+                matchers:
+                  "{% $exists($states.input.matchers) ? $states.input.matchers : {} %}",
+                fallback:
+                  "{% $exists($states.input.fallback) ? $states.input.fallback : {} %}",
+                preprocess:
+                  "{% $exists($states.input.preprocess) ? $states.input.preprocess : null %}",
               },
             },
             Retry: [
@@ -124,6 +143,12 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
                 BackoffRate: 2,
                 JitterStrategy: "FULL",
               },
+              {
+                ErrorEquals: ["ErrChecksumMismatch"],
+                IntervalSeconds: 2,
+                MaxAttempts: 2,
+                BackoffRate: 2,
+              },
             ],
             Next: "Inject fonts map",
             Assign: {
@@ -206,6 +231,10 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
                       s3Key:
                         "{% 'dmq/' & $input.jobId & '/result-' & $suffix & '.png' %}",
                       date: "{% $input.date %}",
+                      timeZone:
+                        "{% $exists($input.timeZone) ? $input.timeZone : '' %}",
+                      // Retried jobs replace the image of the same date
+                      conflict: "replace",
                     },
                   },
                   Retry: [
@@ -216,6 +245,12 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
                       BackoffRate: 2,
                       JitterStrategy: "FULL",
                     },
+                    {
+                      ErrorEquals: ["ErrChecksumMismatch"],
+                      IntervalSeconds: 2,
+                      MaxAttempts: 2,
+                      BackoffRate: 2,
+                    },
                   ],
                   End: true,
                 },
@@ -240,7 +275,24 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
           input: "$util.escapeJavaScript($input.json('$'))",
           stateMachineArn: stateMachine.arn,
           traceHeader: "$method.request.header.X-Amzn-Trace-Id",
-          apiKeyId: "$context.identity.apiKeyId",
+          idempotencyKey: "$util.escapeJavaScript($input.params('Idempotency-Key'))",
+          route: "$context.resourcePath",
+          ...startCallerTemplate,
+          body: "$util.base64Encode($input.body)",
+        }),
+      },
+    },
+    {
+      path: "/unstable/v2/dmq/status/{jobId}",
+      method: "GET",
+      eventHandler: args.statusLambda.lambda,
+      execRole: args.sparkApiGwExec,
+      requestTemplate: {
+        "application/json": pulumi.jsonStringify({
+          jobId: "$util.escapeJavaScript($input.params('jobId'))",
+          stateMachineArn: stateMachine.arn,
+          ...statusCallerTemplate,
+          route: "$context.resourcePath",
         }),
       },
     },
@@ -256,6 +308,13 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
               actions: ["states:StartExecution", "states:StartSyncExecution"],
               resources: [stateMachine.arn],
             },
+            {
+              // Input of an execution started with the same idempotency key
+              actions: ["states:DescribeExecution"],
+              resources: [
+                pulumi.interpolate`arn:aws:states:${args.meta.region}:${args.meta.accountId}:execution:${stateMachine.name}:*`,
+              ],
+            },
           ],
         },
         { parent },
@@ -272,6 +331,38 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
     },
     { parent },
   );
+
+  const statusPolicy = new aws.iam.Policy(
+    `${name}-StatusPolicy`,
+    {
+      policy: aws.iam.getPolicyDocumentOutput(
+        {
+          statements: [
+            {
+              actions: [
+                "states:DescribeExecution",
+                "states:GetExecutionHistory",
+              ],
+              resources: [
+                pulumi.interpolate`arn:aws:states:${args.meta.region}:${args.meta.accountId}:execution:${stateMachine.name}:*`,
+              ],
+            },
+          ],
+        },
+        { parent },
+      ).json,
+    },
+    { parent },
+  );
+
+  new aws.iam.PolicyAttachment(
+    `${name}-StatusPolicy`,
+    {
+      roles: [args.statusLambda.role],
+      policyArn: statusPolicy.arn,
+    },
+    { parent },
+  );
   return {
     routes,
   };
@@ -2,9 +2,38 @@ import * as pulumi from "@pulumi/pulumi";
 import * as aws from "@pulumi/aws";
 import { Arch, GoLambda, HashFolder } from "../components/lambda";
 import { DMQsProps } from "./index";
+import { startCallerTemplate } from "../utils";
+
+interface ConfigPublish {
+  webhookUrl: string;
+  // Optional SecureString parameter with the bearer token of the webhook
+  webhookTokenParam?: string;
+}
+
+// Targets the DMQ is published to and the result image each one gets.
+// The publish Lambda refuses any other target.
+const publishTargets = [
+  { selector: "youtube", suffix: "square" },
+  { selector: "facebook", suffix: "square" },
+  { selector: "instagram", suffix: "vertical" },
+];
 
 export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
   const xray = true;
+  // Optional, without the webhook the publish Lambda fails with no publisher configured
+  const publishConfig = new pulumi.Config().getObject<ConfigPublish>(
+    "dmqPublish",
+  );
+  const webhookTokenStatements = publishConfig?.webhookTokenParam
+    ? [
+        {
+          actions: ["ssm:GetParameter"],
+          resources: [
+            `arn:aws:ssm:${args.meta.region}:${args.meta.accountId}:parameter${publishConfig.webhookTokenParam}`,
+          ],
+        },
+      ]
+    : [];
 
   const procBcktPolicy = new aws.iam.Policy(
     `${name}-s3Policy`,
@@ -14,15 +43,16 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
           statements: [
             {
               effect: "Allow",
-              actions: ["s3:GetObject"],
+              actions: ["s3:GetObject", "s3:PutObject", "s3:DeleteObject"],
               resources: [
                 pulumi.interpolate`${args.procFilesBucket.arn}/dmq/*`,
               ],
             },
-            // {
-            //   actions: ["ssm:GetParameter"],
-            //   resources: [args.gcpConfigParam.arn],
-            // },
+            {
+              actions: ["s3:ListBucket"],
+              resources: [args.procFilesBucket.arn],
+            },
+            ...webhookTokenStatements,
           ],
         },
         { parent },
@@ -45,12 +75,25 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
       logs: { retention: 30 },
       xray,
       env: {
-        variables: {},
+        variables: {
+          WEBHOOK_URL: publishConfig?.webhookUrl ?? "",
+          SSM_WEBHOOK_TOKEN: publishConfig?.webhookTokenParam ?? "",
+          PUBLISH_TARGETS: publishTargets.map((t) => t.selector).join(","),
+        },
       },
     },
     { parent },
   );
 
+  new aws.iam.PolicyAttachment(
+    `${name}-PolicyAttach`,
+    {
+      roles: [publishLambda.role],
+      policyArn: procBcktPolicy.arn,
+    },
+    { parent },
+  );
+
   const stateRole = new aws.iam.Role(
     `${name}-SFSM`,
     {
@@ -164,20 +207,7 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
           },
           Map: {
             Type: "Map",
-            Items: [
-              {
-                suffix: "square",
-                selector: "youtube",
-              },
-              {
-                suffix: "square",
-                selector: "facebook",
-              },
-              {
-                suffix: "vertical",
-                selector: "instagram",
-              },
-            ],
+            Items: publishTargets,
             ItemProcessor: {
               ProcessorConfig: {
                 Mode: "INLINE",
@@ -192,6 +222,10 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
                     FunctionName: pulumi.interpolate`${publishLambda.lambda.arn}:$LATEST`,
                     Payload: {
                       jobId: "{% $input.jobId %}",
+                      target: "{% $states.input.selector %}",
+                      text: "{% $input.text %}",
+                      date: "{% $input.date %}",
+                      dryRun: "{% $exists($input.dryRun) ? $input.dryRun : false %}",
                       s3Bucket: args.procFilesBucket.id,
                       s3Key:
                         "{% 'dmq/' & $input.jobId & '/result-' & $states.input.suffix & '.png' %}",
@@ -269,7 +303,9 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
           input: "$util.escapeJavaScript($input.json('$'))",
           stateMachineArn: stateMachine.arn,
           traceHeader: "$method.request.header.X-Amzn-Trace-Id",
-          apiKeyId: "$context.identity.apiKeyId",
+          route: "$context.resourcePath",
+          ...startCallerTemplate,
+          body: "$util.base64Encode($input.body)",
         }),
       },
     },
@@ -285,6 +321,13 @@ export function create(parent: pulumi.Resource, name: string, args: DMQsProps) {
               actions: ["states:StartExecution", "states:StartSyncExecution"],
               resources: [stateMachine.arn],
             },
+            {
+              // Input of an execution started with the same idempotency key
+              actions: ["states:DescribeExecution"],
+              resources: [
+                pulumi.interpolate`arn:aws:states:${args.meta.region}:${args.meta.accountId}:execution:${stateMachine.name}:*`,
+              ],
+            },
           ],
         },
         { parent },
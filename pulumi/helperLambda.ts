@@ -7,11 +7,13 @@ export interface HelperProps {
   meta: MetaProps;
   procFilesBucket: aws.s3.BucketV2;
   gcpConfigParam: aws.ssm.Parameter;
+  quotaTable: aws.dynamodb.Table;
 }
 
 export default class HelperLambda extends pulumi.ComponentResource {
   public readonly transferLambda: GoLambda;
   public readonly otpLambda: GoLambda;
+  public readonly authLambda: GoLambda;
 
   constructor(
     name: string,
@@ -20,10 +22,17 @@ export default class HelperLambda extends pulumi.ComponentResource {
   ) {
     super("project:components:helperLambda", name, {}, opts);
 
-    const transferLambdaPolicy = {
-      actions: ["ssm:GetParameter"],
-      resources: [args.gcpConfigParam.arn],
-    };
+    const transferLambdaPolicies = [
+      {
+        actions: ["ssm:GetParameter"],
+        resources: [args.gcpConfigParam.arn],
+      },
+      {
+        // Without it a missing resumable session object is reported as AccessDenied instead of NoSuchKey
+        actions: ["s3:ListBucket"],
+        resources: [args.procFilesBucket.arn],
+      },
+    ];
     const otpAuthLambdaPolicy = {
       actions: ["ssm:GetParametersByPath"],
       resources: [
@@ -31,6 +40,30 @@ export default class HelperLambda extends pulumi.ComponentResource {
       ],
     };
 
+    const authPath = `/isha/auth/${pulumi.getStack()}`;
+    const authPepperPath = `/isha/auth-pepper/${pulumi.getStack()}`;
+    const authRevocationPath = `/isha/auth-revoked/${pulumi.getStack()}`;
+
+    const authLambdaPolicies = [
+      {
+        actions: ["ssm:GetParametersByPath"],
+        resources: [
+          `arn:aws:ssm:${args.meta.region}:${args.meta.accountId}:parameter${authPath}`,
+        ],
+      },
+      {
+        actions: ["ssm:GetParameter"],
+        resources: [
+          `arn:aws:ssm:${args.meta.region}:${args.meta.accountId}:parameter${authPepperPath}`,
+          `arn:aws:ssm:${args.meta.region}:${args.meta.accountId}:parameter${authRevocationPath}`,
+        ],
+      },
+      {
+        actions: ["dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem"],
+        resources: [args.quotaTable.arn],
+      },
+    ];
+
     this.transferLambda = new GoLambda(
       `${name}-TransferFiles`,
       {
@@ -40,10 +73,9 @@ export default class HelperLambda extends pulumi.ComponentResource {
           hash: HashFolder("../code/s3-gdrive-transfer/"),
         },
         architecture: Arch.arm,
-        timeout: 300,
+        timeout: 900,
         memory: 256,
-        ephemeralStorage: 10240,
-        rolePolicyStatements: [transferLambdaPolicy],
+        rolePolicyStatements: transferLambdaPolicies,
         xray: true,
         logs: { retention: 30 },
         env: {
@@ -75,9 +107,39 @@ export default class HelperLambda extends pulumi.ComponentResource {
       { parent: this },
     );
 
+    this.authLambda = new GoLambda(
+      `${name}-PskAuth`,
+      {
+        tags: args.meta.tags,
+        source: {
+          code: "../bin/authorizer-psk.zip",
+          hash: HashFolder("../code/authorizer-psk/"),
+        },
+        architecture: Arch.arm,
+        rolePolicyStatements: authLambdaPolicies,
+        xray: true,
+        logs: { retention: 30 },
+        env: {
+          variables: {
+            SSM_LOOKUP_PATH: authPath,
+            SSM_PEPPER_PATH: authPepperPath,
+            SSM_REVOCATION_PATH: authRevocationPath,
+            QUOTA_TABLE: args.quotaTable.name,
+            // Same limits as the former usage plans, a key may override them in its entry.
+            // Daily jobs are only passed to spark, which counts the started ones.
+            RATE_LIMIT: "1",
+            RATE_BURST: "10",
+            DAILY_JOB_QUOTA: "50",
+          },
+        },
+      },
+      { parent: this },
+    );
+
     this.registerOutputs({
       transferLambda: this.transferLambda,
       otpLambda: this.otpLambda,
+      authLambda: this.authLambda,
     });
   }
 }
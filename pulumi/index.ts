@@ -5,7 +5,7 @@ import RestApiGateway from "./components/apiGateway";
 import VideoRender from "./video-render";
 import CommonRes from "./commonRes";
 import HelperLambda from "./helperLambda";
-import { MetaProps } from "./utils";
+import { MetaProps, apiAuth } from "./utils";
 
 interface ConfigDomains {
   api: string;
@@ -43,21 +43,18 @@ async function main() {
     assetsBucket,
     procFilesBucket,
     gcpConfigParam,
+    quotaTable,
     sparkLambda,
+    statusLambda,
     sparkApiGwExec,
     sfnExec,
   } = new CommonRes("CommonRes", meta);
 
-  // const { apiAuthorizer } = new ApiAccess("ApiAuthorizerPSK", {
-  //   codeBucket,
-  //   keys: ["GR/cz"],
-  //   meta,
-  // });
-
   const helperLambda = new HelperLambda("Helper", {
     meta,
     procFilesBucket,
     gcpConfigParam,
+    quotaTable,
   });
 
   const dmqs = new DMQs("DMQs", {
@@ -67,6 +64,7 @@ async function main() {
     procFilesBucket,
     gcpConfigParam,
     sparkLambda,
+    statusLambda,
     otpLambda: helperLambda.otpLambda,
     sparkApiGwExec,
     sfnExec,
@@ -79,6 +77,7 @@ async function main() {
     gcpConfigParam,
     fileTranferLambda: helperLambda.transferLambda,
     sparkLambda,
+    statusLambda,
     sparkApiGwExec,
     sfnExec,
   });
@@ -87,19 +86,38 @@ async function main() {
     tags,
     domain: domains.api,
     xray: true,
-    usagePlans: apiUsers.map((user) => {
-      return {
-        name: user,
-        apiKeys: [
-          {
-            name: "primary",
-          },
-        ],
-        ...usagePlanQuotas,
-      };
-    }),
+    // With "authorizer" keys, their scopes and limits are checked by authorizer-psk, see docs/README.md
+    ...(apiAuth === "authorizer"
+      ? { authorizer: helperLambda.authLambda.lambda }
+      : {
+          usagePlans: apiUsers.map((user) => {
+            return {
+              name: user,
+              apiKeys: [
+                {
+                  name: "primary",
+                },
+              ],
+              ...usagePlanQuotas,
+            };
+          }),
+        }),
 
     routes: [...videoRender.routes, ...dmqs.routes],
+    cors: {
+      allowOrigin: "*",
+      allowHeaders: [
+        "Content-Type",
+        "x-api-key",
+        "Idempotency-Key",
+        // Signed requests
+        "x-key-id",
+        "x-signature",
+        "x-timestamp",
+        "x-nonce",
+        "x-content-sha256",
+      ],
+    },
   });
 }
 
@@ -1,3 +1,4 @@
+import * as pulumi from "@pulumi/pulumi";
 import * as aws from "@pulumi/aws";
 
 export interface MetaProps {
@@ -87,3 +88,40 @@ export function addS3BasicRules(
     );
   }
 }
+
+export type ApiAuth = "usagePlans" | "authorizer";
+
+// How the REST API checks keys, set by the stack config "apiAuth":
+// "usagePlans" (default) with API Gateway keys and usage plans, "authorizer" with authorizer-psk.
+// Switch to "authorizer" only after the keys are imported to the parameter store, see docs/README.md
+export const apiAuth = (new pulumi.Config().get("apiAuth") ?? "usagePlans") as ApiAuth;
+if (apiAuth !== "usagePlans" && apiAuth !== "authorizer") {
+  throw new Error(`Unknown apiAuth "${apiAuth}", expected "usagePlans" or "authorizer"`);
+}
+
+// Caller passed to spark and status in the request templates.
+// Keys of the usage plans are not scoped, their ID stands for the key name and they may use any state machine.
+export const startCallerTemplate =
+  apiAuth === "authorizer"
+    ? {
+        keyName: "$context.authorizer.keyName",
+        stateMachines: "$context.authorizer.stateMachines",
+        dailyJobs: "$context.authorizer.dailyJobs",
+        authMode: "$context.authorizer.authMode",
+        bodySha256: "$context.authorizer.bodySha256",
+      }
+    : {
+        keyName: "$context.identity.apiKeyId",
+        stateMachines: "*",
+      };
+
+export const statusCallerTemplate =
+  apiAuth === "authorizer"
+    ? {
+        keyName: "$context.authorizer.keyName",
+        stateMachines: "$context.authorizer.stateMachines",
+      }
+    : {
+        keyName: "$context.identity.apiKeyId",
+        stateMachines: "*",
+      };
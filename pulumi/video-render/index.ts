@@ -2,7 +2,7 @@ import * as pulumi from "@pulumi/pulumi";
 import * as aws from "@pulumi/aws";
 import { ApiGatewayRoute } from "../components/apiGateway";
 import { Arch, GoLambda, HashFolder, AssumePolicy } from "../components/lambda";
-import { MetaProps } from "../utils";
+import { MetaProps, startCallerTemplate, statusCallerTemplate } from "../utils";
 
 export interface VideoRenderProps {
   meta: MetaProps;
@@ -10,6 +10,7 @@ export interface VideoRenderProps {
   procFilesBucket: aws.s3.BucketV2;
   assetsBucket: aws.s3.BucketV2;
   sparkLambda: GoLambda;
+  statusLambda: GoLambda;
   gcpConfigParam: aws.ssm.Parameter;
   fileTranferLambda: GoLambda;
   sparkApiGwExec: aws.iam.Role;
@@ -35,7 +36,12 @@ export default class VideoRender extends pulumi.ComponentResource {
           {
             statements: [
               {
-                actions: ["s3:PutObject", "s3:GetObject"],
+                actions: [
+                  "s3:PutObject",
+                  "s3:GetObject",
+                  "s3:DeleteObject",
+                  "s3:AbortMultipartUpload",
+                ],
                 resources: [
                   pulumi.interpolate`${args.procFilesBucket.arn}/video-render/*`,
                 ],
@@ -131,13 +137,14 @@ export default class VideoRender extends pulumi.ComponentResource {
         architecture: Arch.arm,
         timeout: 300,
         memory: 256,
-        ephemeralStorage: 10240,
         logs: { retention: 30 },
         env: {
           variables: {
             SSM_GCP_CONFIG: args.gcpConfigParam.name,
             BUCKET_NAME: args.procFilesBucket.id,
             BUCKET_KEY: "video-render/download",
+            UPLOAD_PART_SIZE_MB: "16",
+            UPLOAD_CONCURRENCY: "4",
           },
         },
       },
@@ -280,11 +287,17 @@ export default class VideoRender extends pulumi.ComponentResource {
               Type: "Pass",
               Assign: {
                 jobId: "{% $states.input.jobId %}",
-                videoDriveFolderId: "{% $states.input.videoDriveFolderId %}",
+                videoDriveFolderId:
+                  "{% $exists($states.input.videoDriveFolderId) ? $states.input.videoDriveFolderId : null %}",
+                videoDrivePath:
+                  "{% $exists($states.input.videoDrivePath) ? $states.input.videoDrivePath : null %}",
                 videoDriveId: "{% $states.input.videoDriveId %}",
                 videoFileId:
                   "{% $exists($states.input.videoFileId) ? $states.input.videoFileId : null %}",
-                srtDriveFolderId: "{% $states.input.srtDriveFolderId %}",
+                srtDriveFolderId:
+                  "{% $exists($states.input.srtDriveFolderId) ? $states.input.srtDriveFolderId : null %}",
+                srtDrivePath:
+                  "{% $exists($states.input.srtDrivePath) ? $states.input.srtDrivePath : null %}",
                 srtDriveId: "{% $states.input.srtDriveId %}",
                 destinationFolderId: "{% $states.input.destinationFolderId %}",
                 deliveryWorkflow: "{% $states.input.deliveryWorkflow %}",
@@ -302,6 +315,7 @@ export default class VideoRender extends pulumi.ComponentResource {
                 Payload: {
                   jobId: "{% $jobId %}",
                   sourceDriveFolderId: "{% $videoDriveFolderId %}",
+                  sourceDrivePath: "{% $videoDrivePath %}",
                   driveId: "{% $videoDriveId %}",
                   videoFileId: "{% $videoFileId %}",
                 },
@@ -314,6 +328,12 @@ export default class VideoRender extends pulumi.ComponentResource {
                   BackoffRate: 3,
                   JitterStrategy: "FULL",
                 },
+                {
+                  ErrorEquals: ["ErrChecksumMismatch"],
+                  IntervalSeconds: 2,
+                  MaxAttempts: 2,
+                  BackoffRate: 2,
+                },
               ],
               Catch: [
                 {
@@ -334,6 +354,7 @@ export default class VideoRender extends pulumi.ComponentResource {
                 FunctionName: pulumi.interpolate`${lambdaDocsExtract.lambda.arn}:$LATEST`,
                 Payload: {
                   sourceDriveFolderId: "{% $srtDriveFolderId %}",
+                  sourceDrivePath: "{% $srtDrivePath %}",
                   driveId: "{% $srtDriveId %}",
                   jobId: "{% $jobId %}",
                 },
@@ -477,6 +498,10 @@ export default class VideoRender extends pulumi.ComponentResource {
                   driveFolderId: "{% $destinationFolderId %}",
                   driveFileName: "OUT_video.mp4",
                   mimeType: "video/mp4",
+                  // Re-run of the job doesn't upload the same video again, a different one replaces the old
+                  conflict: "replace",
+                  resumable: true,
+                  chunkSizeMB: 16,
                 },
               },
               Retry: [
@@ -487,6 +512,20 @@ export default class VideoRender extends pulumi.ComponentResource {
                   BackoffRate: 3,
                   JitterStrategy: "FULL",
                 },
+                {
+                  // Resumable upload continues from the last committed chunk.
+                  // Config, permission and checksum errors are permanent and not retried.
+                  ErrorEquals: [
+                    "Sandbox.Timedout",
+                    "TransientError",
+                    "Lambda.ServiceException",
+                    "Lambda.AWSLambdaException",
+                    "Lambda.SdkClientException",
+                  ],
+                  IntervalSeconds: 5,
+                  MaxAttempts: 3,
+                  BackoffRate: 2,
+                },
               ],
               Next: "DeliverChoice",
               Catch: [
@@ -592,7 +631,24 @@ export default class VideoRender extends pulumi.ComponentResource {
             input: "$util.escapeJavaScript($input.json('$'))",
             stateMachineArn: stateMachine.arn,
             traceHeader: "$method.request.header.X-Amzn-Trace-Id",
-            apiKeyId: "$context.identity.apiKeyId",
+            idempotencyKey: "$util.escapeJavaScript($input.params('Idempotency-Key'))",
+            route: "$context.resourcePath",
+            ...startCallerTemplate,
+            body: "$util.base64Encode($input.body)",
+          }),
+        },
+      },
+      {
+        path: "/unstable/v2/video-render/status/{jobId}",
+        method: "GET",
+        eventHandler: args.statusLambda.lambda,
+        execRole: args.sparkApiGwExec,
+        requestTemplate: {
+          "application/json": pulumi.jsonStringify({
+            jobId: "$util.escapeJavaScript($input.params('jobId'))",
+            stateMachineArn: stateMachine.arn,
+            ...statusCallerTemplate,
+            route: "$context.resourcePath",
           }),
         },
       },
@@ -608,6 +664,13 @@ export default class VideoRender extends pulumi.ComponentResource {
                 actions: ["states:StartExecution", "states:StartSyncExecution"],
                 resources: [stateMachine.arn],
               },
+              {
+                // Input of an execution started with the same idempotency key
+                actions: ["states:DescribeExecution"],
+                resources: [
+                  pulumi.interpolate`arn:aws:states:${args.meta.region}:${args.meta.accountId}:execution:${stateMachine.name}:*`,
+                ],
+              },
             ],
           },
           { parent: this },
@@ -624,6 +687,38 @@ export default class VideoRender extends pulumi.ComponentResource {
       },
       { parent: this },
     );
+
+    const statusPolicy = new aws.iam.Policy(
+      `${name}-StatusPolicy`,
+      {
+        policy: aws.iam.getPolicyDocumentOutput(
+          {
+            statements: [
+              {
+                actions: [
+                  "states:DescribeExecution",
+                  "states:GetExecutionHistory",
+                ],
+                resources: [
+                  pulumi.interpolate`arn:aws:states:${args.meta.region}:${args.meta.accountId}:execution:${stateMachine.name}:*`,
+                ],
+              },
+            ],
+          },
+          { parent: this },
+        ).json,
+      },
+      { parent: this },
+    );
+
+    new aws.iam.PolicyAttachment(
+      `${name}-StatusPolicy`,
+      {
+        roles: [args.statusLambda.role],
+        policyArn: statusPolicy.arn,
+      },
+      { parent: this },
+    );
     this.registerOutputs({
       routes: this.routes,
     });
@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Checks the gateway passes the lifecycle and CORS headers of the lambda response to the client.
+# Asks for the status of a job which doesn't exist, so nothing is started.
+# Usage: API_KEY=... scripts/check-api-headers.sh [https://api.isha-automations.fidifis.com]
+
+api_url=${1:-https://api.isha-automations.fidifis.com}
+url="$api_url/unstable/v2/dmq/status/check-headers"
+
+echo "Calling $url..."
+headers=$(curl -sS -o /dev/null -D - -H "x-api-key: ${API_KEY:?API_KEY is required}" -H "Origin: https://example.com" "$url" | tr -d '\r')
+
+failed=0
+check() {
+  local name=$1 pattern=$2
+  local value
+  value=$(echo "$headers" | grep -i "^$name:" | cut -d' ' -f2- || true)
+  if [[ "$value" =~ $pattern ]]; then
+    echo "ok   $name: $value"
+  else
+    echo "FAIL $name: '$value' doesn't match $pattern"
+    failed=1
+  fi
+}
+
+check "API-Version" "^unstable-v2$"
+check "Content-Type" "^application/(problem\+)?json"
+check "Access-Control-Allow-Origin" "."
+check "Access-Control-Expose-Headers" "API-Version"
+
+exit $failed